@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"sort"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+
+	"github.com/tanema/grind/lib/procfile"
+)
+
+// completions only ever read the parsed grind.yml so that they stay fast, nix
+// is never invoked while completing, and parsing never computes sh or file
+// values or decrypts env files.
+
+// isCompletion checks if a command is generating or requesting completions,
+// these should work even if nix is not installed yet.
+func isCompletion(cmd *cobra.Command) bool {
+	switch cmd.Name() {
+	case cobra.ShellCompRequestCmd, cobra.ShellCompNoDescRequestCmd:
+		return true
+	}
+	return cmd.HasParent() && cmd.Parent().Name() == "completion"
+}
+
+func completeServices(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completionNames(pfile.Services, args), cobra.ShellCompDirectiveNoFileComp
+}
+
+func completeService(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	return completeServices(cmd, args, toComplete)
+}
+
+func completeServiceOrTask(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	names := append(completionNames(pfile.Services, args), completionNames(pfile.Tasks, args)...)
+	sort.Strings(names)
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+func completeEnvFormats(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return []string{"dotenv", "sh", "fish", "json", "docker"}, cobra.ShellCompDirectiveNoFileComp
+}
+
+func completeEnvironments(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return pfile.Environments(), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeTaskArgs completes the declared positional args of a task, falling
+// back to files for args that can be anything.
+func completeTaskArgs(task *procfile.Service) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(task.Args) == 0 {
+			return nil, cobra.ShellCompDirectiveDefault
+		} else if len(args) >= len(task.Args) {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return completeArg(task.Args[len(args)])
+	}
+}
+
+func completeArg(arg *procfile.Arg) ([]string, cobra.ShellCompDirective) {
+	if len(arg.Enum) > 0 {
+		return arg.Enum, cobra.ShellCompDirectiveNoFileComp
+	} else if arg.Type == "bool" {
+		return []string{"true", "false"}, cobra.ShellCompDirectiveNoFileComp
+	} else if arg.Type == "int" {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return nil, cobra.ShellCompDirectiveDefault
+}
+
+func completionNames(svcs map[string]*procfile.Service, exclude []string) []string {
+	names := []string{}
+	for name, svc := range svcs {
+		if svc.Hidden || slices.Contains(exclude, name) {
+			continue
+		} else if svc.Description != "" {
+			name += "\t" + svc.Description
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
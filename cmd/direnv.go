@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/tanema/grind/lib/procfile"
+)
+
+// writeEnvrc outputs a script for direnv to put in the directory of a service.
+// Rather than copying the env, it asks grind for it when direnv loads so that
+// it stays up to date, and direnv reloads whenever the grind.yml or any of the
+// env files change.
+func writeEnvrc(w io.Writer, svc *procfile.Service) error {
+	root, err := filepath.Rel(svc.Dir, pfile.Dir)
+	if err != nil {
+		return err
+	}
+	lines := []string{
+		fmt.Sprintf("# generated by grind, update with: grind direnv %v > .envrc", svc.Name),
+		fmt.Sprintf("watch_file %q", filepath.Join(root, filepath.Base(pfile.Filepath))),
+	}
+	for _, file := range svc.EnvFiles() {
+		path, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		} else if path, err = filepath.Rel(svc.Dir, path); err != nil {
+			return err
+		}
+		lines = append(lines, fmt.Sprintf("watch_file %q", path))
+	}
+	if len(svc.Nixpkgs) > 0 {
+		lines = append(lines, "use nix -p "+strings.Join(svc.Nixpkgs, " "))
+	}
+	lines = append(lines, fmt.Sprintf(`eval "$(cd %q && grind env %v --format sh --only-defined --show-secrets)"`, root, svc.Name))
+	_, err = fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
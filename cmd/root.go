@@ -1,20 +1,35 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
+	"github.com/tanema/grind/lib/envfile"
 	"github.com/tanema/grind/lib/procfile"
 	"github.com/tanema/grind/lib/runner"
+	"github.com/tanema/grind/lib/secrets"
 	"github.com/tanema/grind/lib/term"
 )
 
 var (
-	pfile *procfile.Procfile
+	pfile       *procfile.Procfile
+	logFormat   = runner.LogText
+	timestamps  bool
+	attach      string
+	trace       string
+	yes         bool
+	envName     string
+	explain     bool
+	envFormat   = envfile.FormatDotenv
+	onlyDefined bool
+	showSecrets bool
 
 	rootCmd = &cobra.Command{
 		Version: "0.0.1",
@@ -35,7 +50,7 @@ Run all of your services concurrently within a nix-shell`,
 		Short:        "Run all services in their own nix-shell.",
 		SilenceUsage: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runner.New(pfile).RunServices(args)
+			return newRunner().RunServices(args)
 		},
 	}
 	shellCmd = &cobra.Command{
@@ -43,7 +58,7 @@ Run all of your services concurrently within a nix-shell`,
 		Short: "Start up interactive shell with deps.",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runner.New(pfile).RunShell(args[0])
+			return newRunner().RunShell(args[0])
 		},
 	}
 	execCmd = &cobra.Command{
@@ -51,25 +66,76 @@ Run all of your services concurrently within a nix-shell`,
 		Short: "run a command within the environment",
 		Args:  cobra.MinimumNArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runner.New(pfile).RunCommand(args[0], strings.Join(args[1:], " "))
+			return newRunner().RunCommand(args[0], strings.Join(args[1:], " "))
+		},
+	}
+	psCmd = &cobra.Command{
+		Use:   "ps",
+		Short: "Show what grind run is running and when scheduled tasks run next.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			state, err := runner.ReadState(pfile)
+			if err != nil {
+				return err
+			} else if state == nil {
+				return fmt.Errorf("grind run is not running in %v", pfile.Dir)
+			}
+			return state.Print()
 		},
 	}
 	envCmd = &cobra.Command{
-		Use:    "env [service]",
-		Short:  "Output environment variables for different services.",
-		Args:   cobra.ExactArgs(1),
-		Hidden: true,
-		Run: func(cmd *cobra.Command, args []string) {
-			var env []string
-			if svc := pfile.Services[args[0]]; svc != nil {
-				env = svc.Environ()
-			} else if task := pfile.Tasks[args[0]]; task != nil {
-				env = task.Environ()
-			} else {
-				fmt.Printf("Uknown service or task %v", args[0])
-				return
+		Use:          "env [service]",
+		Short:        "Output environment variables for a service or task.",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			svc, err := pfile.Find(args[0])
+			if err != nil {
+				return err
+			} else if err := svc.ComputeEnv(context.Background(), runner.NixExecutor{}); err != nil {
+				return err
+			} else if explain {
+				return explainEnv(svc)
+			}
+			env := map[string]string{}
+			for key, val := range svc.Env {
+				env[key] = val
+			}
+			if !onlyDefined {
+				for _, pair := range svc.Environ() {
+					key, val, _ := strings.Cut(pair, "=")
+					env[key] = val
+				}
 			}
-			fmt.Println(strings.Join(env, "\n"))
+			if !showSecrets {
+				for key := range env {
+					if svc.IsSecret(key) {
+						env[key] = secrets.Mask
+					}
+				}
+			}
+			return envfile.Write(os.Stdout, envFormat, env)
+		},
+	}
+	envExampleCmd = &cobra.Command{
+		Use:   "env-example",
+		Short: "Output an env file with every variable in the env_schema, like a .env.example.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return pfile.WriteEnvExample(os.Stdout)
+		},
+	}
+	direnvCmd = &cobra.Command{
+		Use:          "direnv [service]",
+		Short:        "Output an .envrc that loads the nix packages and env of a service.",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			svc, err := pfile.Find(args[0])
+			if err != nil {
+				return err
+			}
+			return writeEnvrc(os.Stdout, svc)
 		},
 	}
 )
@@ -85,41 +151,131 @@ func init() {
 	var err error
 	var file string
 
-	rootCmd.CompletionOptions.DisableDefaultCmd = true
 	rootCmd.SetUsageFunc(usage)
 	rootCmd.SetHelpFunc(help)
 	rootCmd.PersistentFlags().StringVarP(&file, "file", "f", "./grind.yml", "Specify a grindfile path to load.")
+	rootCmd.PersistentFlags().BoolVar(&timestamps, "timestamps", false, "Prefix every line of output with the time it was written.")
+	rootCmd.PersistentFlags().StringVar(&trace, "trace", "", "Write a chrome trace of every step and command to a file.")
+	rootCmd.PersistentFlags().BoolVarP(&yes, "yes", "y", false, "Accept all confirmations and use defaults for prompts.")
+	rootCmd.PersistentFlags().Var(&logFormat, "log-format", "Output format for services and tasks: text, json, or logfmt.")
+	rootCmd.PersistentFlags().StringVar(&envName, "env", "", "Select an environment like staging, defaults to $"+procfile.EnvironmentVar+".")
 
-	pfile, err = procfile.Parse(file)
+	parseEarlyFlags()
+	if envName == "" && !isCompleting() {
+		envName = os.Getenv(procfile.EnvironmentVar)
+	}
+	pfile, err = procfile.ParseEnv(file, envName)
 	if !os.IsNotExist(err) {
 		cobra.CheckErr(err)
 	} else if pfile == nil {
 		rootCmd.AddCommand(initCmd)
 		return
 	}
-	rootCmd.AddCommand(runCmd, envCmd, shellCmd, execCmd)
-	for name, task := range pfile.Tasks {
-		use := name
-		if task.Usage != "" {
-			use = task.Usage
+	rootCmd.RegisterFlagCompletionFunc("env", completeEnvironments)
+	runCmd.Flags().StringVarP(&attach, "attach", "a", "", "Attach stdin to a service on start. Press ctrl-] to detach.")
+	runCmd.ValidArgsFunction = completeServices
+	shellCmd.ValidArgsFunction = completeService
+	execCmd.ValidArgsFunction = completeService
+	envCmd.Flags().BoolVar(&explain, "explain", false, "Show where the final value of every variable came from.")
+	envCmd.Flags().VarP(&envFormat, "format", "o", "Output format: dotenv, sh, fish, json, or docker.")
+	envCmd.Flags().BoolVar(&onlyDefined, "only-defined", false, "Only output variables set by grind, not the inherited host env.")
+	envCmd.Flags().BoolVar(&showSecrets, "show-secrets", false, "Output the values of secrets instead of masking them.")
+	envCmd.RegisterFlagCompletionFunc("format", completeEnvFormats)
+	envCmd.ValidArgsFunction = completeServiceOrTask
+	direnvCmd.ValidArgsFunction = completeServiceOrTask
+	secretsCmd.AddCommand(secretsEncryptCmd, secretsDecryptCmd, secretsEditCmd)
+	rootCmd.AddCommand(runCmd, psCmd, envCmd, envExampleCmd, direnvCmd, secretsCmd, shellCmd, execCmd)
+	for _, task := range pfile.Tasks {
+		rootCmd.AddCommand(taskCmd(task))
+	}
+}
+
+func taskCmd(task *procfile.Service) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    task.UseLine(),
+		Hidden: task.Hidden,
+		Short:  task.Description,
+		Long:   taskLong(task),
+		RunE:   runTask(task.Name),
+
+		ValidArgsFunction: completeTaskArgs(task),
+	}
+	for _, flag := range task.Flags {
+		if flag.Type == "bool" {
+			cmd.Flags().BoolP(flag.Name, flag.Short, flag.Default == "true", flag.Help())
+			continue
 		}
-		rootCmd.AddCommand(&cobra.Command{
-			Use:    use,
-			Hidden: task.Hidden,
-			Short:  task.Description,
-			RunE:   runTask(name),
+		cmd.Flags().StringP(flag.Name, flag.Short, flag.Default, flag.Help())
+		flag := flag
+		cmd.RegisterFlagCompletionFunc(flag.Name, func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+			return completeArg(flag)
 		})
 	}
+	return cmd
+}
+
+func taskLong(task *procfile.Service) string {
+	if len(task.Args) == 0 {
+		return task.Description
+	}
+	lines := []string{task.Description, "", "Arguments:"}
+	for _, arg := range task.Args {
+		help := arg.Help()
+		if arg.Default != "" {
+			help += fmt.Sprintf(" (default %v)", arg.Default)
+		}
+		lines = append(lines, fmt.Sprintf("  %-12v %v", arg.Name, help))
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
 }
 
 func runTask(taskName string) func(*cobra.Command, []string) error {
 	return func(cmd *cobra.Command, args []string) error {
-		return runner.New(pfile).RunTask(taskName, args)
+		flags := map[string]string{}
+		cmd.Flags().Visit(func(flag *pflag.Flag) {
+			flags[flag.Name] = flag.Value.String()
+		})
+		return newRunner().RunTask(taskName, true, args, flags)
 	}
 }
 
+// parseEarlyFlags parses the global flags that are needed to load the grind.yml,
+// before cobra parses everything else, since tasks are added as commands from
+// it. Completion requests skip this so that a partial --env does not fail.
+func parseEarlyFlags() {
+	if len(os.Args) < 2 || isCompleting() {
+		return
+	}
+	flags := pflag.NewFlagSet("grind", pflag.ContinueOnError)
+	flags.ParseErrorsWhitelist.UnknownFlags = true
+	flags.Usage = func() {}
+	flags.SetOutput(io.Discard)
+	flags.AddFlagSet(rootCmd.PersistentFlags())
+	flags.Parse(os.Args[1:])
+}
+
+// isCompleting checks if grind was called by the shell for completions. The
+// environment is not selected while completing so that an unknown $GRIND_ENV
+// does not break completion for the whole shell.
+func isCompleting() bool {
+	return len(os.Args) > 1 && (os.Args[1] == cobra.ShellCompRequestCmd || os.Args[1] == cobra.ShellCompNoDescRequestCmd)
+}
+
+func newRunner() *runner.Runner {
+	return runner.New(runner.Config{
+		Procfile:   pfile,
+		LogFormat:  logFormat,
+		Timestamps: timestamps,
+		Attach:     attach,
+		Trace:      trace,
+		Yes:        yes,
+	})
+}
+
 func ensureNix(cmd *cobra.Command, args []string) {
-	if _, err := exec.LookPath("nix"); err == nil {
+	if isCompletion(cmd) {
+		return
+	} else if _, err := exec.LookPath("nix"); err == nil {
 		return
 	}
 	term.Println(`{{"nix" | cyan | bold}} not found on your system. Please run the following command to install it.
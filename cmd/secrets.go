@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tanema/grind/lib/secrets"
+)
+
+var (
+	secretsCmd = &cobra.Command{
+		Use:   "secrets",
+		Short: "Encrypt, decrypt, and edit encrypted env files.",
+		Long: `Encrypt, decrypt, and edit encrypted env files.
+
+Files are encrypted in the age format with the passphrase in ` + secrets.PassphraseEnv + `,
+or the identities in .grind/key.txt, which is created the first time a file is
+encrypted. Set ` + secrets.IdentityEnv + ` to use an identity file somewhere else.`,
+	}
+	secretsEncryptCmd = &cobra.Command{
+		Use:          "encrypt [file]",
+		Short:        "Encrypt an env file into [file].enc so that it can be committed.",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			} else if secrets.IsEncrypted(data) {
+				return fmt.Errorf("%v is already encrypted", args[0])
+			}
+			encrypted, err := secretKeys().Encrypt(data)
+			if err != nil {
+				return err
+			} else if err := os.WriteFile(args[0]+".enc", encrypted, 0644); err != nil {
+				return err
+			}
+			fmt.Printf("encrypted %v into %v, add it to envs and remove %v\n", args[0], args[0]+".enc", args[0])
+			return nil
+		},
+	}
+	secretsDecryptCmd = &cobra.Command{
+		Use:          "decrypt [file]",
+		Short:        "Output the decrypted contents of an encrypted env file.",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, encrypted, err := secretKeys().ReadFile(args[0])
+			if err != nil {
+				return err
+			} else if !encrypted {
+				return fmt.Errorf("%v is not encrypted", args[0])
+			}
+			_, err = os.Stdout.Write(data)
+			return err
+		},
+	}
+	secretsEditCmd = &cobra.Command{
+		Use:          "edit [file]",
+		Short:        "Edit an encrypted env file in $EDITOR, creating it if it does not exist.",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return editSecrets(args[0])
+		},
+	}
+)
+
+func secretKeys() *secrets.Keys {
+	return &secrets.Keys{Dir: pfile.Dir}
+}
+
+// editSecrets decrypts a file into a private temp file, opens it in the editor,
+// and encrypts it again if it was changed.
+func editSecrets(path string) error {
+	keys := secretKeys()
+	plain, encrypted, err := keys.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	} else if err == nil && !encrypted {
+		return fmt.Errorf("%v is not encrypted, use grind secrets encrypt first", path)
+	}
+	tmp, err := os.CreateTemp("", "grind-secrets-*.env")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(plain); err != nil {
+		tmp.Close()
+		return err
+	} else if err := tmp.Close(); err != nil {
+		return err
+	}
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	parts := strings.Fields(editor)
+	edit := exec.Command(parts[0], append(parts[1:], tmp.Name())...)
+	edit.Stdin, edit.Stdout, edit.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := edit.Run(); err != nil {
+		return fmt.Errorf("%v exited with %v, %v was not changed", editor, err, path)
+	}
+	edited, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return err
+	} else if encrypted && bytes.Equal(plain, edited) {
+		fmt.Printf("%v was not changed\n", path)
+		return nil
+	}
+	data, err := keys.Encrypt(edited)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"strings"
+
 	"github.com/spf13/cobra"
 	"github.com/tanema/grind/lib/procfile"
+	"github.com/tanema/grind/lib/secrets"
 	"github.com/tanema/grind/lib/term"
 )
 
@@ -52,6 +55,13 @@ Use {{"grind [command] --help" | bold | bright}} for more information about a co
 {{- end}}
 `
 
+const explainTemplate = `{{- range .Vars}}
+{{.Key | bold}}={{.Value}} {{.Source | cyan}}{{if .Computed}} {{print "computed by " .Computed | yellow}}{{end}}{{if .Overrides}} {{print "overrides " .Overrides | faint}}{{end}}
+{{- end}}
+{{- if .Host}}
+{{print "and " .Host " variables from the host" | faint}}
+{{- end}}`
+
 func usage(c *cobra.Command) error {
 	return term.Println(usageTemplate, struct {
 		Cmd *cobra.Command
@@ -68,3 +78,24 @@ func help(c *cobra.Command, args []string) {
 		panic(err)
 	}
 }
+
+// explainEnv prints where every variable for a service came from. Variables
+// that are only set by the host are counted rather than listed.
+func explainEnv(svc *procfile.Service) error {
+	type row struct {
+		procfile.EnvVar
+		Overrides string
+	}
+	host, rows := 0, []row{}
+	for _, v := range svc.Explain() {
+		if v.Source == "host" {
+			host++
+			continue
+		}
+		if svc.IsSecret(v.Key) {
+			v.Value = secrets.Mask
+		}
+		rows = append(rows, row{EnvVar: v, Overrides: strings.Join(v.Overrides, ", ")})
+	}
+	return term.Println(explainTemplate, map[string]any{"Host": host, "Vars": rows})
+}
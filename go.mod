@@ -3,12 +3,18 @@ module github.com/tanema/grind
 go 1.20
 
 require (
+	filippo.io/age v1.1.1
+	github.com/creack/pty v1.1.18
 	github.com/fatih/color v1.14.1
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/spf13/cobra v1.6.1
+	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.8.2
 	golang.org/x/exp v0.0.0-20230315142452-642cacee5cc0
+	golang.org/x/sys v0.6.0
 	golang.org/x/term v0.6.0
 	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -17,7 +23,5 @@ require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.17 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
-	golang.org/x/sys v0.6.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	golang.org/x/crypto v0.4.0 // indirect
 )
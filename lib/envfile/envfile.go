@@ -1,14 +1,36 @@
 package envfile
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+	"unicode"
+
+	"github.com/tanema/grind/lib/expand"
 )
 
+// parser is a tokenizer for env files that follows the common dotenv format.
+// Values can be unquoted, single quoted which are taken literally, double
+// quoted which support escapes and interpolation, or multi-line blocks with
+// triple quotes or heredocs.
+type parser struct {
+	name string
+	src  []rune
+	pos  int
+	line int
+	env  map[string]string
+	vars []Var
+}
+
+// Var is a single variable defined in an env file
+type Var struct {
+	Key   string
+	Value string
+	Line  int
+}
+
 // Parse will load one or many env files into an Env object
 func Parse(env map[string]string, paths ...string) error {
 	if env == nil {
@@ -22,6 +44,31 @@ func Parse(env map[string]string, paths ...string) error {
 	return nil
 }
 
+// Read parses a single env file and returns its variables in the order they
+// are defined. Values can reference the variables in env, which is not changed.
+func Read(filename string, env map[string]string) ([]Var, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Decode(filename, f, env)
+}
+
+// Decode is the same as Read but parses the env file from a reader, the name
+// is only used for errors.
+func Decode(name string, r io.Reader, env map[string]string) ([]Var, error) {
+	scope := map[string]string{}
+	for key, val := range env {
+		scope[key] = val
+	}
+	p, err := parseEnvFile(name, r, scope)
+	if err != nil {
+		return nil, err
+	}
+	return p.vars, nil
+}
+
 func loadEnvFile(filename string, env map[string]string) error {
 	if filename == "" {
 		return nil
@@ -31,53 +78,309 @@ func loadEnvFile(filename string, env map[string]string) error {
 		return err
 	}
 	defer f.Close()
-	return parseEnvFile(f, env)
+	_, err = parseEnvFile(filename, f, env)
+	return err
 }
 
-func parseEnvFile(f io.Reader, env map[string]string) error {
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if len(line) == 0 || line[0] == '#' {
+func parseEnvFile(name string, f io.Reader, env map[string]string) (*parser, error) {
+	src, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{name: name, src: []rune(string(src)), line: 1, env: env}
+	for {
+		p.skip(" \t\r\n")
+		if p.eof() {
+			return p, nil
+		} else if p.peek() == '#' {
+			p.skipLine()
 			continue
 		}
-		line = os.Expand(line, func(v string) string {
-			if val, ok := env[v]; ok {
-				return val
-			}
-			return os.Getenv(v)
-		})
-		if eq := strings.Index(line, "="); eq >= 0 && len(line) > eq {
-			key := strings.TrimSpace(line[:eq])
-			val := strings.TrimSpace(line[eq+1:])
-			if strings.HasPrefix(line[eq+1:], "<<") {
-				heredoc := strings.Split(val, " ")[0]
-				env[key] = parseBlock(val, heredoc, strings.TrimPrefix(heredoc, "<<"), scanner)
-			} else if strings.HasPrefix(val, `"""`) {
-				env[key] = parseBlock(val, `"""`, `"""`, scanner)
-			} else if strings.HasPrefix(val, "'''") {
-				env[key] = parseBlock(val, "'''", "'''", scanner)
-			} else {
-				env[key] = strings.Trim(val, "\"'")
-			}
+		line := p.line
+		key, val, err := p.parseLine()
+		if err != nil {
+			return nil, err
+		}
+		env[key] = val
+		p.vars = append(p.vars, Var{Key: key, Value: val, Line: line})
+	}
+}
+
+func (p *parser) parseLine() (string, string, error) {
+	key := p.readKey()
+	if key == "export" && p.skip(" \t") > 0 && isKeyStart(p.peek()) {
+		key = p.readKey()
+	}
+	if key == "" {
+		return "", "", p.errorf("expected a variable name but found %q", p.peek())
+	}
+	p.skip(" \t")
+	if p.peek() != '=' {
+		return "", "", p.errorf("expected = after %v", key)
+	}
+	p.pos++
+	p.skip(" \t")
+	var val string
+	var err error
+	switch {
+	case p.hasPrefix(`"""`):
+		val, err = p.readBlock(`"""`, `"""`, true)
+	case p.hasPrefix(`'''`):
+		val, err = p.readBlock(`'''`, `'''`, false)
+	case p.hasPrefix(`<<`):
+		val, err = p.readHeredoc()
+	case p.peek() == '"':
+		val, err = p.readDoubleQuoted()
+	case p.peek() == '\'':
+		val, err = p.readSingleQuoted()
+	default:
+		val, err = p.readUnquoted()
+	}
+	return key, val, err
+}
+
+func (p *parser) readKey() string {
+	start := p.pos
+	if !p.eof() && isKeyStart(p.peek()) {
+		p.pos++
+		for !p.eof() && isKeyChar(p.peek()) {
+			p.pos++
+		}
+	}
+	return string(p.src[start:p.pos])
+}
+
+// readUnquoted reads the rest of the line, ending at an inline comment that is
+// preceded by whitespace. A backslash at the end of a line continues the value
+// on the next line.
+func (p *parser) readUnquoted() (string, error) {
+	line := p.line
+	var val strings.Builder
+	for !p.eof() {
+		ch := p.peek()
+		if ch == '\n' {
+			break
+		} else if ch == '#' && (val.Len() == 0 || unicode.IsSpace(p.src[p.pos-1])) {
+			p.skipLine()
+			break
+		} else if ch == '\\' && p.continuation() {
+			continue
+		}
+		val.WriteRune(ch)
+		p.pos++
+	}
+	return p.expand(line, strings.TrimRight(val.String(), " \t\r"))
+}
+
+func (p *parser) readSingleQuoted() (string, error) {
+	line := p.line
+	p.pos++
+	start := p.pos
+	for !p.eof() && p.peek() != '\'' {
+		p.next()
+	}
+	if p.eof() {
+		return "", p.errorAt(line, "unterminated single quoted value")
+	}
+	val := string(p.src[start:p.pos])
+	p.pos++
+	return val, p.endOfValue()
+}
+
+func (p *parser) readDoubleQuoted() (string, error) {
+	line := p.line
+	p.pos++
+	var val strings.Builder
+	for {
+		if p.eof() {
+			return "", p.errorAt(line, "unterminated double quoted value")
+		}
+		ch := p.next()
+		if ch == '"' {
+			break
+		} else if ch != '\\' {
+			val.WriteRune(ch)
+			continue
+		} else if p.eof() {
+			continue
 		}
+		switch esc := p.next(); esc {
+		case 'n':
+			val.WriteRune('\n')
+		case 'r':
+			val.WriteRune('\r')
+		case 't':
+			val.WriteRune('\t')
+		case '\n':
+			// a backslash at the end of the line continues onto the next line
+		case '$':
+			// escaped for the expander so that it is output as a literal $
+			val.WriteString("$$")
+		case '"', '\\', '\'', '`':
+			val.WriteRune(esc)
+		default:
+			val.WriteRune('\\')
+			val.WriteRune(esc)
+		}
+	}
+	if err := p.endOfValue(); err != nil {
+		return "", err
 	}
-	return scanner.Err()
+	return p.expand(line, val.String())
 }
 
-func parseBlock(value, start, end string, scanner *bufio.Scanner) string {
+// readBlock reads a value that spans multiple lines until a line that only
+// contains the end delimiter.
+func (p *parser) readBlock(start, end string, interpolate bool) (string, error) {
+	line := p.line
+	p.pos += len([]rune(start))
 	parts := []string{}
-	firstLine := strings.TrimPrefix(value, start)
-	fmt.Println(value, firstLine, start, start == value)
-	if strings.TrimSpace(firstLine) != "" {
-		parts = append(parts, strings.TrimSpace(firstLine))
+	if first := strings.TrimSpace(p.readRawLine()); first != "" {
+		parts = append(parts, first)
 	}
-	for scanner.Scan() {
-		if part := scanner.Text(); part == end {
+	for {
+		if p.eof() {
+			return "", p.errorAt(line, "unterminated %v block", start)
+		}
+		part := p.readRawLine()
+		if strings.TrimRight(part, "\r") == end {
 			break
-		} else {
-			parts = append(parts, part)
 		}
+		parts = append(parts, strings.TrimRight(part, "\r"))
+	}
+	val := strings.Join(parts, "\n")
+	if interpolate {
+		return p.expand(line, val)
+	}
+	return val, nil
+}
+
+// readHeredoc reads a <<TAG block, a quoted tag like <<'TAG' disables
+// interpolation the same way it does in a shell.
+func (p *parser) readHeredoc() (string, error) {
+	p.pos += 2
+	start := p.pos
+	for !p.eof() && !unicode.IsSpace(p.peek()) {
+		p.pos++
+	}
+	tag := string(p.src[start:p.pos])
+	raw := strings.Trim(tag, `'"`)
+	if raw == "" {
+		return "", p.errorf("heredoc is missing a closing tag name")
+	}
+	p.pos = start - 2
+	return p.readBlock("<<"+tag, raw, raw == tag)
+}
+
+// expand interpolates variables in a value, values can reference variables
+// defined earlier in the file or in the environment.
+func (p *parser) expand(line int, val string) (string, error) {
+	val, err := expand.Expander{
+		Lookup: func(name string) (string, bool) {
+			if val, ok := p.env[name]; ok {
+				return val, true
+			}
+			return os.LookupEnv(name)
+		},
+		Assign: func(name, val string) { p.env[name] = val },
+	}.Expand(val)
+	if err != nil {
+		return "", p.errorAt(line, "%v", err)
+	}
+	return val, nil
+}
+
+// endOfValue makes sure that nothing but whitespace or a comment follows a
+// quoted value on the same line.
+func (p *parser) endOfValue() error {
+	p.skip(" \t\r")
+	if p.eof() || p.peek() == '\n' {
+		return nil
+	} else if p.peek() == '#' {
+		p.skipLine()
+		return nil
+	}
+	return p.errorf("unexpected %q after quoted value", p.peek())
+}
+
+// continuation consumes a backslash followed by a newline
+func (p *parser) continuation() bool {
+	rest := p.src[p.pos+1:]
+	if len(rest) > 0 && rest[0] == '\n' {
+		p.pos += 2
+		p.line++
+		return true
+	} else if len(rest) > 1 && rest[0] == '\r' && rest[1] == '\n' {
+		p.pos += 3
+		p.line++
+		return true
+	}
+	return false
+}
+
+func (p *parser) readRawLine() string {
+	start := p.pos
+	for !p.eof() && p.peek() != '\n' {
+		p.pos++
+	}
+	line := string(p.src[start:p.pos])
+	if !p.eof() {
+		p.next()
 	}
-	return strings.Join(parts, "\n")
+	return line
+}
+
+func (p *parser) skipLine() {
+	for !p.eof() && p.peek() != '\n' {
+		p.pos++
+	}
+}
+
+func (p *parser) skip(chars string) int {
+	skipped := 0
+	for !p.eof() && strings.ContainsRune(chars, p.peek()) {
+		p.next()
+		skipped++
+	}
+	return skipped
+}
+
+func (p *parser) hasPrefix(prefix string) bool {
+	return strings.HasPrefix(string(p.src[p.pos:]), prefix)
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *parser) peek() rune {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *parser) next() rune {
+	ch := p.src[p.pos]
+	p.pos++
+	if ch == '\n' {
+		p.line++
+	}
+	return ch
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return p.errorAt(p.line, format, args...)
+}
+
+func (p *parser) errorAt(line int, format string, args ...any) error {
+	return fmt.Errorf("%v:%v: %v", p.name, line, fmt.Sprintf(format, args...))
+}
+
+func isKeyStart(ch rune) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isKeyChar(ch rune) bool {
+	return isKeyStart(ch) || (ch >= '0' && ch <= '9') || ch == '.'
 }
@@ -2,6 +2,7 @@ package envfile
 
 import (
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -11,7 +12,7 @@ func TestEnvFileParse(t *testing.T) {
 	info := map[string]string{}
 	assert.Nil(t, Parse(info, "./test/valid.env", ""))
 	assert.Equal(t, "xyz123", info["SIMPLE"])
-	assert.Equal(t, `Multiple\nLines and variable substitution: xyz123`, info["INTERPOLATED"])
+	assert.Equal(t, "Multiple\nLines and variable substitution: xyz123", info["INTERPOLATED"])
 	assert.Equal(t, "raw text without variable interpolation", info["NON_INTERPOLATED"])
 	assert.Equal(t, `long text here,
 e.g. a private SSH key`, info["MULTILINE"])
@@ -24,3 +25,122 @@ yes`, info["HEREDOC"])
 	assert.NotNil(t, err)
 	assert.True(t, os.IsNotExist(err))
 }
+
+func TestEnvFileFixtures(t *testing.T) {
+	testCases := []struct {
+		file     string
+		expected map[string]string
+	}{
+		{
+			file: "export.env",
+			expected: map[string]string{
+				"EXPORTED": "yes",
+				"SPACED":   "also yes",
+				"export":   "not a keyword",
+			},
+		},
+		{
+			file: "comments.env",
+			expected: map[string]string{
+				"INLINE":        "value",
+				"HASH":          "value#not-a-comment",
+				"QUOTED_HASH":   "value # not a comment",
+				"SINGLE_HASH":   "#kept",
+				"EMPTY":         "",
+				"EMPTY_COMMENT": "",
+			},
+		},
+		{
+			file: "quotes.env",
+			expected: map[string]string{
+				"BASE":           "base",
+				"ESCAPES":        "tab\there\nnewline \"quoted\" back\\slash $BASE",
+				"INTERP":         "base/path and base",
+				"LITERAL":        `${BASE} \n stays`,
+				"UNQUOTED":       "base/bin",
+				"MULTI":          "first\nsecond",
+				"UNKNOWN_ESCAPE": `a\qb`,
+			},
+		},
+		{
+			file: "continuation.env",
+			expected: map[string]string{
+				"UNQUOTED": "one two three",
+				"QUOTED":   "one two",
+				"AFTER":    "after",
+			},
+		},
+		{
+			file: "blocks.env",
+			expected: map[string]string{
+				"NAME":        "grind",
+				"DOUBLE":      "hello grind",
+				"SINGLE":      "hello ${NAME}",
+				"HEREDOC":     "hello grind",
+				"RAW_HEREDOC": "hello ${NAME}",
+			},
+		},
+		{
+			file: "expansion.env",
+			expected: map[string]string{
+				"HOST":      "localhost",
+				"PORT":      "5432",
+				"USER_NAME": "grind",
+				"URL":       "postgres://grind@localhost:5432/grind",
+				"DEBUG":     "true",
+				"PRICE":     "$$5",
+				"ESCAPED":   `$HOST \localhost`,
+			},
+		},
+		{
+			file:     "crlf.env",
+			expected: map[string]string{"CRLF": "windows", "QUOTED_CRLF": "quoted"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.file, func(t *testing.T) {
+			env := map[string]string{}
+			assert.Nil(t, Parse(env, "./test/"+tc.file))
+			assert.Equal(t, tc.expected, env)
+		})
+	}
+}
+
+func TestEnvFileErrors(t *testing.T) {
+	testCases := []struct {
+		src string
+		err string
+	}{
+		{src: "KEY value", err: "test.env:1: expected = after KEY"},
+		{src: "# comment\n=value", err: `test.env:2: expected a variable name but found '='`},
+		{src: "1KEY=value", err: `test.env:1: expected a variable name but found '1'`},
+		{src: "A=1\nKEY=\"value\n\nB=2", err: "test.env:2: unterminated double quoted value"},
+		{src: "KEY='value", err: "test.env:1: unterminated single quoted value"},
+		{src: "KEY=\"value\" trailing", err: `test.env:1: unexpected 't' after quoted value`},
+		{src: "KEY='value'\"", err: `test.env:1: unexpected '"' after quoted value`},
+		{src: "\n\nKEY=\"\"\"\nvalue", err: `test.env:3: unterminated """ block`},
+		{src: "KEY=<<EOT\nvalue\nEOF", err: "test.env:1: unterminated <<EOT block"},
+		{src: "KEY=<<\nvalue", err: "test.env:1: heredoc is missing a closing tag name"},
+		{src: "A=1\nURL=\"${HOST:?set the host}\"", err: "test.env:2: HOST: set the host"},
+		{src: "URL=${HOST:?}", err: "test.env:1: HOST: required variable is not set"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			_, err := parseEnvFile("test.env", strings.NewReader(tc.src), map[string]string{})
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestEnvFileRead(t *testing.T) {
+	env := map[string]string{"HOST": "db.local"}
+	vars, err := Read("./test/expansion.env", env)
+	assert.Nil(t, err)
+	assert.Equal(t, Var{Key: "HOST", Value: "localhost", Line: 1}, vars[0])
+	assert.Equal(t, Var{Key: "URL", Value: "postgres://grind@localhost:5432/grind", Line: 3}, vars[2])
+	assert.Len(t, vars, 6)
+	assert.Equal(t, map[string]string{"HOST": "db.local"}, env)
+
+	_, err = Read("./test/notther.env", env)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,122 @@
+package envfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Format is an output format for writing out environment variables
+type Format string
+
+const (
+	// FormatDotenv writes KEY=value lines that can be read back by this package
+	FormatDotenv Format = "dotenv"
+	// FormatSh writes export statements for posix shells
+	FormatSh Format = "sh"
+	// FormatFish writes set statements for the fish shell
+	FormatFish Format = "fish"
+	// FormatJSON writes a single json object of all the variables
+	FormatJSON Format = "json"
+	// FormatDocker writes an env file for docker run --env-file which does not
+	// support quoting, so values are written as they are.
+	FormatDocker Format = "docker"
+)
+
+// unquotedPattern matches values that are safe to write without quotes
+var unquotedPattern = regexp.MustCompile(`^[a-zA-Z0-9_./:@,+=%-]*$`)
+
+// String implements pflag.Value
+func (format Format) String() string {
+	if format == "" {
+		return string(FormatDotenv)
+	}
+	return string(format)
+}
+
+// Set implements pflag.Value and validates the requested format
+func (format *Format) Set(val string) error {
+	switch Format(val) {
+	case FormatDotenv, FormatSh, FormatFish, FormatJSON, FormatDocker:
+		*format = Format(val)
+		return nil
+	}
+	return fmt.Errorf("unknown env format %v, expected one of dotenv, sh, fish, json, docker", val)
+}
+
+// Type implements pflag.Value
+func (format Format) Type() string {
+	return "format"
+}
+
+// Write outputs the variables in env, sorted by key, in the given format
+func Write(w io.Writer, format Format, env map[string]string) error {
+	if format == FormatJSON {
+		data, err := json.MarshalIndent(env, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+	keys := []string{}
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		line, err := format.line(key, env[key])
+		if err != nil {
+			return err
+		} else if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (format Format) line(key, val string) (string, error) {
+	switch format {
+	case FormatSh:
+		return fmt.Sprintf("export %v=%v", key, shQuote(val)), nil
+	case FormatFish:
+		return fmt.Sprintf("set -gx %v %v", key, fishQuote(val)), nil
+	case FormatDocker:
+		if strings.ContainsAny(val, "\r\n") {
+			return "", fmt.Errorf("%v has a multi-line value which cannot be written in the docker format", key)
+		}
+		return key + "=" + val, nil
+	}
+	if unquotedPattern.MatchString(val) {
+		return key + "=" + val, nil
+	}
+	return fmt.Sprintf("%v=%v", key, dotenvQuote(val)), nil
+}
+
+// shQuote wraps a value in single quotes, which take everything literally, so
+// the only thing to escape is the single quote itself.
+func shQuote(val string) string {
+	return "'" + strings.ReplaceAll(val, "'", `'\''`) + "'"
+}
+
+// fishQuote wraps a value in single quotes where fish only treats \\ and \' as
+// escapes.
+func fishQuote(val string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(val) + "'"
+}
+
+// dotenvQuote wraps a value in double quotes, escaping anything that the
+// parser would otherwise expand or end the value on.
+func dotenvQuote(val string) string {
+	return `"` + strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		`$`, `\$`,
+		"\n", `\n`,
+		"\r", `\r`,
+		"\t", `\t`,
+	).Replace(val) + `"`
+}
@@ -0,0 +1,53 @@
+package envfile
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	env := map[string]string{"PORT": "8080", "MSG": "it's $HOME\n\"done\""}
+	testCases := []struct {
+		format Format
+		out    string
+	}{
+		{format: FormatDotenv, out: "MSG=\"it's \\$HOME\\n\\\"done\\\"\"\nPORT=8080\n"},
+		{format: FormatSh, out: "export MSG='it'\\''s $HOME\n\"done\"'\nexport PORT='8080'\n"},
+		{format: FormatFish, out: "set -gx MSG 'it\\'s $HOME\n\"done\"'\nset -gx PORT '8080'\n"},
+		{format: FormatJSON, out: "{\n  \"MSG\": \"it's $HOME\\n\\\"done\\\"\",\n  \"PORT\": \"8080\"\n}\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(string(tc.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			require.Nil(t, Write(&buf, tc.format, env))
+			assert.Equal(t, tc.out, buf.String())
+		})
+	}
+
+	var buf bytes.Buffer
+	assert.EqualError(t, Write(&buf, FormatDocker, env), "MSG has a multi-line value which cannot be written in the docker format")
+	buf.Reset()
+	require.Nil(t, Write(&buf, FormatDocker, map[string]string{"MSG": "it's $HOME"}))
+	assert.Equal(t, "MSG=it's $HOME\n", buf.String())
+}
+
+func TestWriteDotenvRoundTrip(t *testing.T) {
+	env := map[string]string{"A": `back\slash "quoted" $NOT_EXPANDED`, "B": "multi\nline\ttab", "C": "# not a comment"}
+	var buf bytes.Buffer
+	require.Nil(t, Write(&buf, FormatDotenv, env))
+	parsed := map[string]string{}
+	_, err := parseEnvFile("roundtrip.env", &buf, parsed)
+	require.Nil(t, err)
+	assert.Equal(t, env, parsed)
+}
+
+func TestFormatSet(t *testing.T) {
+	var format Format
+	assert.Equal(t, "dotenv", format.String())
+	assert.Nil(t, format.Set("fish"))
+	assert.Equal(t, FormatFish, format)
+	assert.NotNil(t, format.Set("yaml"))
+}
@@ -0,0 +1,182 @@
+package expand
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Expander interpolates variables into strings using shell style parameter
+// expansion. It supports $VAR and ${VAR}, along with ${VAR:-default},
+// ${VAR:=default}, ${VAR:?message}, ${VAR:+alternate}, and the same operators
+// without the colon which only check if the variable is unset rather than
+// empty. Use $$ for a literal $.
+type Expander struct {
+	// Lookup finds the value of a variable, it defaults to os.LookupEnv
+	Lookup func(name string) (string, bool)
+	// Assign is called to set the default of ${VAR:=default}, if it is nil the
+	// default is only substituted.
+	Assign func(name, val string)
+	// Passthrough leaves the substitutions that are not supported as they are,
+	// like ${VAR%suffix}, ${#VAR}, or ${VAR:1:2}, instead of failing so that a
+	// shell can expand them.
+	Passthrough bool
+}
+
+// RequiredError is returned when a variable referenced with ${VAR:?message}
+// is not set.
+type RequiredError struct {
+	Name    string
+	Message string
+}
+
+func (err *RequiredError) Error() string {
+	if err.Message == "" {
+		return fmt.Sprintf("%v: required variable is not set", err.Name)
+	}
+	return fmt.Sprintf("%v: %v", err.Name, err.Message)
+}
+
+// errBadSubstitution is returned for substitutions that are not supported
+var errBadSubstitution = errors.New("bad substitution")
+
+// Expand uses the environment of the current process to expand a string
+func Expand(str string) (string, error) {
+	return Expander{}.Expand(str)
+}
+
+// Expand replaces every variable reference in the string
+func (e Expander) Expand(str string) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(str); i++ {
+		if str[i] != '$' || i+1 == len(str) {
+			out.WriteByte(str[i])
+			continue
+		}
+		switch next := str[i+1]; {
+		case next == '$':
+			out.WriteByte('$')
+			i++
+		case next == '{':
+			end := closingBrace(str, i+2)
+			if end < 0 {
+				out.WriteString(str[i:])
+				return out.String(), nil
+			}
+			val, err := e.param(str[i+2 : end])
+			if errors.Is(err, errBadSubstitution) && e.Passthrough {
+				val, err = str[i:end+1], nil
+			}
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(val)
+			i = end
+		default:
+			name := readName(str[i+1:])
+			if name == "" {
+				out.WriteByte('$')
+				continue
+			}
+			val, _ := e.lookup(name)
+			out.WriteString(val)
+			i += len(name)
+		}
+	}
+	return out.String(), nil
+}
+
+// param expands the inside of ${...}
+func (e Expander) param(expr string) (string, error) {
+	name := readName(expr)
+	if name == "" {
+		return "", fmt.Errorf("%w ${%v}", errBadSubstitution, expr)
+	}
+	val, ok := e.lookup(name)
+	rest := expr[len(name):]
+	if rest == "" {
+		return val, nil
+	}
+	set := ok
+	if rest[0] == ':' {
+		set = ok && val != ""
+		rest = rest[1:]
+	}
+	if rest == "" {
+		return "", fmt.Errorf("%w ${%v}", errBadSubstitution, expr)
+	}
+	op, word := rest[0], rest[1:]
+	switch op {
+	case '-', '=':
+		if set {
+			return val, nil
+		}
+		def, err := e.Expand(word)
+		if err == nil && op == '=' && e.Assign != nil {
+			e.Assign(name, def)
+		}
+		return def, err
+	case '?':
+		if set {
+			return val, nil
+		}
+		msg, err := e.Expand(word)
+		if err != nil {
+			return "", err
+		}
+		return "", &RequiredError{Name: name, Message: msg}
+	case '+':
+		if !set {
+			return "", nil
+		}
+		return e.Expand(word)
+	}
+	return "", fmt.Errorf("%w ${%v}", errBadSubstitution, expr)
+}
+
+func (e Expander) lookup(name string) (string, bool) {
+	if e.Lookup == nil {
+		return os.LookupEnv(name)
+	}
+	return e.Lookup(name)
+}
+
+// readName reads a variable name from the start of a string. Names are either
+// identifiers, a single digit for positional args, or @ and * for all args.
+func readName(str string) string {
+	if str == "" {
+		return ""
+	} else if c := str[0]; c == '@' || c == '*' || (c >= '0' && c <= '9') {
+		return str[:1]
+	}
+	i := 0
+	for i < len(str) && (str[i] == '_' || isAlpha(str[i]) || (i > 0 && isDigit(str[i]))) {
+		i++
+	}
+	return str[:i]
+}
+
+// closingBrace finds the brace that closes a ${ while skipping nested ones
+func closingBrace(str string, start int) int {
+	depth := 1
+	for i := start; i < len(str); i++ {
+		switch str[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
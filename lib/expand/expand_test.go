@@ -0,0 +1,74 @@
+package expand
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpand(t *testing.T) {
+	env := map[string]string{"NAME": "grind", "EMPTY": "", "1": "first", "@": "first second"}
+	expander := Expander{
+		Lookup: func(name string) (string, bool) {
+			val, ok := env[name]
+			return val, ok
+		},
+		Assign: func(name, val string) { env[name] = val },
+	}
+	testCases := []struct {
+		in, out string
+	}{
+		{in: "plain text", out: "plain text"},
+		{in: "$NAME and ${NAME}", out: "grind and grind"},
+		{in: "$NAME.yml", out: "grind.yml"},
+		{in: "$MISSING|${MISSING}", out: "|"},
+		{in: "$1 $@", out: "first first second"},
+		{in: "$$NAME costs $5 $", out: "$NAME costs  $"},
+		{in: "echo $(pwd) 100%", out: "echo $(pwd) 100%"},
+		{in: "${MISSING:-default}", out: "default"},
+		{in: "${EMPTY:-default}|${EMPTY-default}", out: "default|"},
+		{in: "${NAME:-default}", out: "grind"},
+		{in: "${MISSING:-${NAME}-dev}", out: "grind-dev"},
+		{in: "${NAME:+set}|${EMPTY:+set}|${EMPTY+set}|${MISSING+set}", out: "set||set|"},
+		{in: "${NAME:?is required}", out: "grind"},
+		{in: "${ASSIGNED:=assigned} $ASSIGNED", out: "assigned assigned"},
+		{in: "${UNCLOSED", out: "${UNCLOSED"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.in, func(t *testing.T) {
+			out, err := expander.Expand(tc.in)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestExpandErrors(t *testing.T) {
+	expander := Expander{Lookup: func(string) (string, bool) { return "", false }}
+	_, err := expander.Expand("${DB_HOST:?set the database host}")
+	assert.EqualError(t, err, "DB_HOST: set the database host")
+	assert.IsType(t, &RequiredError{}, err)
+
+	_, err = expander.Expand("${DB_HOST:?}")
+	assert.EqualError(t, err, "DB_HOST: required variable is not set")
+
+	_, err = expander.Expand("${DB_HOST:-${PORT:?missing}}")
+	assert.EqualError(t, err, "PORT: missing")
+
+	_, err = expander.Expand("${DB HOST}")
+	assert.NotNil(t, err)
+	_, err = expander.Expand("${}")
+	assert.NotNil(t, err)
+}
+
+func TestExpandPassthrough(t *testing.T) {
+	expander := Expander{
+		Lookup:      func(name string) (string, bool) { return "grind.yml", name == "FILE" },
+		Passthrough: true,
+	}
+	out, err := expander.Expand("echo ${FILE%.yml} ${#FILE} ${FILE:1:2} ${FILE:-default}")
+	assert.Nil(t, err)
+	assert.Equal(t, "echo ${FILE%.yml} ${#FILE} ${FILE:1:2} grind.yml", out)
+	_, err = expander.Expand("${MISSING:?required}")
+	assert.EqualError(t, err, "MISSING: required")
+}
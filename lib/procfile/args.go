@@ -0,0 +1,228 @@
+package procfile
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// Arg is a declared positional argument or flag for a task. Values are
+// validated before the task is run, then exposed to commands as an env var
+// with the upper cased name, and as a named interpolation like ${name}.
+type Arg struct {
+	Name        string   `yaml:"name"`
+	Short       string   `yaml:"short,omitempty"`
+	Description string   `yaml:"desc,omitempty"`
+	Type        string   `yaml:"type,omitempty"`
+	Required    bool     `yaml:"required,omitempty"`
+	Default     string   `yaml:"default,omitempty"`
+	Enum        []string `yaml:"enum,omitempty"`
+}
+
+var argNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]*$`)
+
+// globalFlags are the flags that every grind command accepts, with their
+// shorthands, which the flags of a task cannot reuse.
+var globalFlags = map[string]string{
+	"env":        "",
+	"file":       "f",
+	"help":       "h",
+	"log-format": "",
+	"timestamps": "",
+	"trace":      "",
+	"yes":        "y",
+}
+
+// EnvName is the name of the env var that the value is set to
+func (arg *Arg) EnvName() string {
+	return envName(arg.Name)
+}
+
+func envName(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// UseName is how the arg is displayed in the usage line
+func (arg *Arg) UseName() string {
+	if arg.Required {
+		return "<" + arg.Name + ">"
+	}
+	return "[" + arg.Name + "]"
+}
+
+// Help is the description of the arg along with its constraints
+func (arg *Arg) Help() string {
+	help := []string{}
+	if arg.Description != "" {
+		help = append(help, arg.Description)
+	}
+	if arg.Type == "int" {
+		help = append(help, "("+arg.Type+")")
+	}
+	if len(arg.Enum) > 0 {
+		help = append(help, "(one of: "+strings.Join(arg.Enum, ", ")+")")
+	}
+	if arg.Required {
+		help = append(help, "(required)")
+	}
+	return strings.Join(help, " ")
+}
+
+// Validate will check a value against the type and enum of the arg, returning
+// the value in its normalized form.
+func (arg *Arg) Validate(val string) (string, error) {
+	switch arg.Type {
+	case "int":
+		if _, err := strconv.Atoi(val); err != nil {
+			return "", fmt.Errorf("invalid value %q for %v: expected an int", val, arg.Name)
+		}
+	case "bool":
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return "", fmt.Errorf("invalid value %q for %v: expected a bool", val, arg.Name)
+		}
+		val = strconv.FormatBool(b)
+	}
+	if len(arg.Enum) > 0 && !slices.Contains(arg.Enum, val) {
+		return "", fmt.Errorf("invalid value %q for %v: expected one of %v", val, arg.Name, strings.Join(arg.Enum, ", "))
+	}
+	return val, nil
+}
+
+func (arg *Arg) setup(task string) error {
+	if arg.Type == "" {
+		arg.Type = "string"
+	}
+	if !argNamePattern.MatchString(arg.Name) {
+		return fmt.Errorf("task %v has an invalid arg name %q", task, arg.Name)
+	} else if arg.Type != "string" && arg.Type != "int" && arg.Type != "bool" {
+		return fmt.Errorf("task %v arg %v has unknown type %v, expected string, int, or bool", task, arg.Name, arg.Type)
+	} else if len(arg.Short) > 1 {
+		return fmt.Errorf("task %v arg %v short flag should be a single character", task, arg.Name)
+	}
+	if arg.Default != "" {
+		val, err := arg.Validate(arg.Default)
+		if err != nil {
+			return fmt.Errorf("task %v default: %v", task, err)
+		}
+		arg.Default = val
+	}
+	return nil
+}
+
+// checkGlobal makes sure that a flag does not clash with a global flag
+func (arg *Arg) checkGlobal(task string) error {
+	if _, ok := globalFlags[arg.Name]; ok {
+		return fmt.Errorf("task %v flag %v is already a global flag", task, arg.Name)
+	}
+	for name, short := range globalFlags {
+		if arg.Short != "" && arg.Short == short {
+			return fmt.Errorf("task %v flag %v short flag %v is already used by the global flag %v", task, arg.Name, arg.Short, name)
+		}
+	}
+	return nil
+}
+
+// UseLine generates the usage for a task from its declared args
+func (svc *Service) UseLine() string {
+	if svc.Usage != "" {
+		return svc.Usage
+	}
+	use := []string{svc.Name}
+	for _, arg := range svc.Args {
+		use = append(use, arg.UseName())
+	}
+	return strings.Join(use, " ")
+}
+
+// ParseArgs validates the positional args and flags that were passed to a
+// task against the declared ones. It returns the values for every declared arg
+// and flag, with defaults applied. Tasks without declared args accept anything.
+func (svc *Service) ParseArgs(args []string, flags map[string]string) (map[string]string, error) {
+	vals := map[string]string{}
+	if len(svc.Args) > 0 && len(args) > len(svc.Args) {
+		return nil, fmt.Errorf("%v accepts at most %v arg(s), received %v", svc.Name, len(svc.Args), len(args))
+	}
+	for i, arg := range svc.Args {
+		if i < len(args) {
+			val, err := arg.Validate(args[i])
+			if err != nil {
+				return nil, err
+			}
+			vals[arg.Name] = val
+		} else if arg.Required {
+			return nil, fmt.Errorf("%v is missing required arg %v", svc.Name, arg.Name)
+		} else if arg.Default != "" {
+			vals[arg.Name] = arg.Default
+		}
+	}
+	for _, flag := range svc.Flags {
+		if val, ok := flags[flag.Name]; ok {
+			val, err := flag.Validate(val)
+			if err != nil {
+				return nil, err
+			}
+			vals[flag.Name] = val
+		} else if flag.Required {
+			return nil, fmt.Errorf("%v is missing required flag --%v", svc.Name, flag.Name)
+		} else if flag.Default != "" {
+			vals[flag.Name] = flag.Default
+		} else if flag.Type == "bool" {
+			vals[flag.Name] = "false"
+		}
+	}
+	return vals, nil
+}
+
+// ArgEnv converts named values, like the ones returned by ParseArgs or
+// prompts, into env vars.
+func (svc *Service) ArgEnv(vals map[string]string) map[string]string {
+	env := map[string]string{}
+	for name, val := range vals {
+		env[envName(name)] = val
+	}
+	return env
+}
+
+func (svc *Service) setupArgs() error {
+	seen := map[string]bool{}
+	optional := false
+	for _, arg := range svc.Args {
+		if err := arg.setup(svc.Name); err != nil {
+			return err
+		} else if seen[arg.Name] {
+			return fmt.Errorf("task %v declares %v more than once", svc.Name, arg.Name)
+		} else if arg.Required && optional {
+			return fmt.Errorf("task %v required arg %v cannot come after an optional arg", svc.Name, arg.Name)
+		}
+		optional = optional || !arg.Required
+		seen[arg.Name] = true
+	}
+	for _, flag := range svc.Flags {
+		if err := flag.setup(svc.Name); err != nil {
+			return err
+		} else if err := flag.checkGlobal(svc.Name); err != nil {
+			return err
+		} else if seen[flag.Name] {
+			return fmt.Errorf("task %v declares %v more than once", svc.Name, flag.Name)
+		}
+		seen[flag.Name] = true
+	}
+	for _, prompt := range svc.Prompts {
+		if err := prompt.setup(svc.Name); err != nil {
+			return err
+		}
+	}
+	if err := svc.setupMatrix(seen); err != nil {
+		return err
+	}
+	for _, output := range svc.Outputs {
+		if !envNamePattern.MatchString(output) {
+			return fmt.Errorf("task %v has an invalid output name %q", svc.Name, output)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package procfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testTask(t *testing.T) *Service {
+	task := &Service{
+		Name: "test",
+		Args: []*Arg{
+			{Name: "pkg", Required: true},
+			{Name: "count", Type: "int", Default: "1"},
+		},
+		Flags: []*Arg{
+			{Name: "race", Type: "bool"},
+			{Name: "db-engine", Enum: []string{"mysql", "postgres"}, Default: "mysql"},
+		},
+	}
+	assert.Nil(t, task.setupArgs())
+	return task
+}
+
+func TestSetupArgs(t *testing.T) {
+	task := testTask(t)
+	assert.Equal(t, "string", task.Args[0].Type)
+	assert.Equal(t, "test <pkg> [count]", task.UseLine())
+
+	bad := []*Service{
+		{Name: "t", Args: []*Arg{{Name: "1pkg"}}},
+		{Name: "t", Args: []*Arg{{Name: "pkg", Type: "float"}}},
+		{Name: "t", Args: []*Arg{{Name: "pkg", Type: "int", Default: "one"}}},
+		{Name: "t", Args: []*Arg{{Name: "pkg"}, {Name: "count", Required: true}}},
+		{Name: "t", Args: []*Arg{{Name: "pkg"}}, Flags: []*Arg{{Name: "pkg"}}},
+		{Name: "t", Flags: []*Arg{{Name: "race", Short: "ra"}}},
+		{Name: "t", Flags: []*Arg{{Name: "env"}}},
+		{Name: "t", Flags: []*Arg{{Name: "yolo", Short: "y"}}},
+		{Name: "t", Outputs: []string{"GIT-SHA"}},
+	}
+	for _, task := range bad {
+		assert.NotNil(t, task.setupArgs())
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	task := testTask(t)
+
+	vals, err := task.ParseArgs([]string{"./lib"}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"pkg": "./lib", "count": "1", "race": "false", "db-engine": "mysql"}, vals)
+
+	vals, err = task.ParseArgs([]string{"./lib", "3"}, map[string]string{"race": "1", "db-engine": "postgres"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"pkg": "./lib", "count": "3", "race": "true", "db-engine": "postgres"}, vals)
+	assert.Equal(t, map[string]string{"PKG": "./lib", "COUNT": "3", "RACE": "true", "DB_ENGINE": "postgres"}, task.ArgEnv(vals))
+
+	_, err = task.ParseArgs([]string{}, nil)
+	assert.EqualError(t, err, "test is missing required arg pkg")
+	_, err = task.ParseArgs([]string{"./lib", "3", "extra"}, nil)
+	assert.EqualError(t, err, "test accepts at most 2 arg(s), received 3")
+	_, err = task.ParseArgs([]string{"./lib", "three"}, nil)
+	assert.EqualError(t, err, `invalid value "three" for count: expected an int`)
+	_, err = task.ParseArgs([]string{"./lib"}, map[string]string{"db-engine": "sqlite"})
+	assert.EqualError(t, err, `invalid value "sqlite" for db-engine: expected one of mysql, postgres`)
+
+	vals, err = (&Service{Name: "free"}).ParseArgs([]string{"a", "b", "c"}, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, vals)
+}
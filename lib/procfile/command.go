@@ -0,0 +1,108 @@
+package procfile
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Command is a single entry in the before, cmds, or after lists of a service.
+// It can either be written as a plain string, or as an object so that options
+// like guards, timeouts, and retries can be set on it.
+type Command struct {
+	Run         string            `yaml:"run"`
+	If          string            `yaml:"if,omitempty"`
+	Unless      string            `yaml:"unless,omitempty"`
+	Platforms   []string          `yaml:"platforms,omitempty"`
+	Exists      string            `yaml:"exists,omitempty"`
+	Missing     string            `yaml:"missing,omitempty"`
+	Dir         string            `yaml:"dir,omitempty"`
+	Env         map[string]string `yaml:"env,omitempty"`
+	Timeout     time.Duration     `yaml:"timeout,omitempty"`
+	Retries     int               `yaml:"retries,omitempty"`
+	RetryDelay  time.Duration     `yaml:"retry_delay,omitempty"`
+	IgnoreError bool              `yaml:"ignore_error,omitempty"`
+	Capture     string            `yaml:"capture,omitempty"`
+}
+
+var envNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// DefaultRetryDelay is how long to wait between retries if retry_delay is not set
+const DefaultRetryDelay = time.Second
+
+// platformAliases are friendlier names for GOOS values
+var platformAliases = map[string]string{
+	"macos": "darwin",
+	"osx":   "darwin",
+}
+
+// UnmarshalYAML allows a command to be defined as a string or an object
+func (cmd *Command) UnmarshalYAML(unmarshal func(any) error) error {
+	var run string
+	if err := unmarshal(&run); err == nil {
+		*cmd = Command{Run: run}
+		return nil
+	}
+	type rawCommand Command
+	var raw rawCommand
+	if err := unmarshal(&raw); err != nil {
+		return err
+	} else if raw.Run == "" {
+		return fmt.Errorf("command is missing run")
+	} else if raw.Retries < 0 || raw.Timeout < 0 || raw.RetryDelay < 0 {
+		return fmt.Errorf("command %v cannot have a negative timeout, retries, or retry_delay", raw.Run)
+	}
+	if raw.Timeout > 0 && strings.HasPrefix(raw.Run, ".@") {
+		return fmt.Errorf("command %v cannot have a timeout, set the timeout on the commands of the task instead", raw.Run)
+	}
+	if raw.Capture != "" {
+		if !envNamePattern.MatchString(raw.Capture) {
+			return fmt.Errorf("command %v cannot capture into invalid name %q", raw.Run, raw.Capture)
+		} else if strings.HasPrefix(raw.Run, ".@") {
+			return fmt.Errorf("command %v cannot capture the output of a task, use outputs on the task instead", raw.Run)
+		}
+	}
+	if raw.Retries > 0 && raw.RetryDelay == 0 {
+		raw.RetryDelay = DefaultRetryDelay
+	}
+	*cmd = Command(raw)
+	return nil
+}
+
+// MarshalYAML will output commands without options as a plain string
+func (cmd Command) MarshalYAML() (any, error) {
+	type rawCommand Command
+	if reflect.DeepEqual(cmd, Command{Run: cmd.Run}) {
+		return cmd.Run, nil
+	}
+	return rawCommand(cmd), nil
+}
+
+// Task returns the name of the task if this command calls another task with .@
+func (cmd *Command) Task() (string, bool) {
+	if strings.HasPrefix(cmd.Run, ".@") {
+		return strings.TrimPrefix(cmd.Run, ".@"), true
+	}
+	return "", false
+}
+
+// OnPlatform checks if the command should run on the current platform. Platforms
+// are matched against GOOS, or GOOS/GOARCH, and macos is accepted for darwin.
+func (cmd *Command) OnPlatform() bool {
+	if len(cmd.Platforms) == 0 {
+		return true
+	}
+	for _, platform := range cmd.Platforms {
+		goos, goarch, _ := strings.Cut(strings.ToLower(platform), "/")
+		if alias, ok := platformAliases[goos]; ok {
+			goos = alias
+		}
+		if goos == runtime.GOOS && (goarch == "" || goarch == runtime.GOARCH) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package procfile
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestCommandYAML(t *testing.T) {
+	var cmds []*Command
+	assert.Nil(t, yaml.UnmarshalStrict([]byte(`
+- echo hi
+- run: rm -rf ./tmp
+  exists: ./tmp
+  platforms: [linux]
+`), &cmds))
+	assert.Equal(t, []*Command{
+		{Run: "echo hi"},
+		{Run: "rm -rf ./tmp", Exists: "./tmp", Platforms: []string{"linux"}},
+	}, cmds)
+
+	out, err := yaml.Marshal(cmds)
+	assert.Nil(t, err)
+	assert.Equal(t, "- echo hi\n- run: rm -rf ./tmp\n  platforms:\n  - linux\n  exists: ./tmp\n", string(out))
+
+	assert.NotNil(t, yaml.UnmarshalStrict([]byte(`- if: "true"`), &cmds))
+	assert.NotNil(t, yaml.UnmarshalStrict([]byte(`- run: ls
+  iff: "true"`), &cmds))
+}
+
+func TestCommandOptions(t *testing.T) {
+	var cmds []*Command
+	assert.Nil(t, yaml.UnmarshalStrict([]byte(`
+- run: go test
+  dir: server
+  env: {GOFLAGS: -race}
+  timeout: 30s
+  retries: 2
+  ignore_error: true
+`), &cmds))
+	assert.Equal(t, []*Command{{
+		Run:         "go test",
+		Dir:         "server",
+		Env:         map[string]string{"GOFLAGS": "-race"},
+		Timeout:     30 * time.Second,
+		Retries:     2,
+		RetryDelay:  DefaultRetryDelay,
+		IgnoreError: true,
+	}}, cmds)
+
+	assert.NotNil(t, yaml.UnmarshalStrict([]byte(`- {run: ls, retries: -1}`), &cmds))
+	assert.NotNil(t, yaml.UnmarshalStrict([]byte(`- {run: ls, timeout: soon}`), &cmds))
+	assert.NotNil(t, yaml.UnmarshalStrict([]byte(`- {run: git rev-parse HEAD, capture: GIT-SHA}`), &cmds))
+	assert.NotNil(t, yaml.UnmarshalStrict([]byte(`- {run: .@sha, capture: SHA}`), &cmds))
+	assert.EqualError(t, yaml.UnmarshalStrict([]byte(`- {run: .@build, timeout: 1m}`), &cmds), "command .@build cannot have a timeout, set the timeout on the commands of the task instead")
+}
+
+func TestCommandTask(t *testing.T) {
+	name, ok := (&Command{Run: ".@test"}).Task()
+	assert.True(t, ok)
+	assert.Equal(t, "test", name)
+	_, ok = (&Command{Run: "go test"}).Task()
+	assert.False(t, ok)
+}
+
+func TestCommandOnPlatform(t *testing.T) {
+	assert.True(t, (&Command{}).OnPlatform())
+	assert.True(t, (&Command{Platforms: []string{runtime.GOOS}}).OnPlatform())
+	assert.True(t, (&Command{Platforms: []string{"plan9", runtime.GOOS + "/" + runtime.GOARCH}}).OnPlatform())
+	assert.False(t, (&Command{Platforms: []string{runtime.GOOS + "/nope"}}).OnPlatform())
+	assert.False(t, (&Command{Platforms: []string{"plan9"}}).OnPlatform())
+	if runtime.GOOS == "darwin" {
+		assert.True(t, (&Command{Platforms: []string{"macos"}}).OnPlatform())
+	}
+}
@@ -0,0 +1,214 @@
+package procfile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/tanema/grind/lib/secrets"
+)
+
+// EnvValue is a single value in the env of the grind.yml or a service. It can
+// either be written as a plain string, or as an object that computes the value
+// from the output of a shell command like {sh: git rev-parse HEAD} or the
+// contents of a file like {file: ~/.token}. Computed values, and the values of
+// encrypted env files, are empty until ComputeEnv is called on the service.
+type EnvValue struct {
+	Value string `yaml:"-"`
+	Sh    string `yaml:"sh,omitempty"`
+	File  string `yaml:"file,omitempty"`
+}
+
+// Executor builds the process that runs the command of an sh value in the
+// nixpkgs of a service, it is implemented by the executors of the runner. The
+// dir and env of the command are set when it is run.
+type Executor interface {
+	Command(ctx context.Context, svc *Service, shellCmd string, keep []string) *exec.Cmd
+}
+
+// envComputer computes the sh and file values of an env. Commands are run in
+// the nix env of the service in its dir, and files are relative to its dir.
+type envComputer struct {
+	procfile *Procfile
+	dir      string
+	nixpkgs  []string
+	isolated bool
+}
+
+// UnmarshalYAML allows an env value to be defined as a string or an object
+func (val *EnvValue) UnmarshalYAML(unmarshal func(any) error) error {
+	var plain string
+	if err := unmarshal(&plain); err == nil {
+		*val = EnvValue{Value: plain}
+		return nil
+	}
+	type rawValue EnvValue
+	var raw rawValue
+	if err := unmarshal(&raw); err != nil {
+		return err
+	} else if (raw.Sh == "") == (raw.File == "") {
+		return fmt.Errorf("env value must set one of sh or file")
+	}
+	*val = EnvValue(raw)
+	return nil
+}
+
+// MarshalYAML will output plain values as a string
+func (val EnvValue) MarshalYAML() (any, error) {
+	type rawValue EnvValue
+	if !val.IsComputed() {
+		return val.Value, nil
+	}
+	return rawValue(val), nil
+}
+
+// IsComputed checks if the value comes from a command or a file
+func (val EnvValue) IsComputed() bool {
+	return val.Sh != "" || val.File != ""
+}
+
+// String describes where a computed value comes from, like sh: whoami
+func (val EnvValue) String() string {
+	if val.Sh != "" {
+		return "sh: " + val.Sh
+	} else if val.File != "" {
+		return "file: " + val.File
+	}
+	return val.Value
+}
+
+// computer returns the computer for the values in the env of the service
+func (svc *Service) computer() *envComputer {
+	return &envComputer{procfile: svc.procfile, dir: svc.Dir, nixpkgs: svc.Nixpkgs, isolated: svc.Isolated}
+}
+
+// computer returns the computer for the values in the global env, which run
+// with the global nixpkgs in the dir of the grind.yml.
+func (procfile *Procfile) computer() *envComputer {
+	return &envComputer{procfile: procfile, dir: procfile.Dir, nixpkgs: procfile.Nixpkgs}
+}
+
+// ComputeEnv runs the sh commands and reads the files of the computed values in
+// the env of the service, and decrypts its encrypted env files. Parsing leaves
+// these empty so that loading the grind.yml for help or completion never runs
+// anything or needs a key, so this has to be called before the env is used to
+// run the service or output its env. The commands are run with the executor,
+// and stopped if the ctx is cancelled.
+func (svc *Service) ComputeEnv(ctx context.Context, executor Executor) error {
+	procfile := svc.procfile
+	procfile.mut.Lock()
+	defer procfile.mut.Unlock()
+	if svc.envComputed || !svc.layers.hasComputed() && !svc.hasSkippedFiles() {
+		return nil
+	} else if !procfile.computing {
+		procfile.computing = true
+		procfile.envLayers = map[string]envStack{}
+	}
+	procfile.ctx, procfile.executor = ctx, executor
+	defer func() { procfile.ctx, procfile.executor = nil, nil }()
+	if err := svc.setupEnv(); err != nil {
+		return fmt.Errorf("%v: %v", svc.Name, err)
+	}
+	svc.envComputed = true
+	return nil
+}
+
+// hasSkippedFiles checks if any env file of the service was skipped until the
+// env is computed.
+func (svc *Service) hasSkippedFiles() bool {
+	for _, file := range svc.EnvFiles() {
+		if svc.procfile.skipped[file] {
+			return true
+		}
+	}
+	return false
+}
+
+// skip checks if an env file should be skipped because the env is not being
+// computed, and it is encrypted or is a .enc file that is yet to be created by
+// grind secrets encrypt.
+func (c *envComputer) skip(file string) bool {
+	if c.procfile.computing {
+		return false
+	}
+	data, err := os.ReadFile(file)
+	if (errors.Is(err, os.ErrNotExist) && strings.HasSuffix(file, ".enc")) || (err == nil && secrets.IsEncrypted(data)) {
+		c.procfile.skipped[file] = true
+		return true
+	}
+	return false
+}
+
+// sh runs a command and returns its trimmed stdout. Each command is only run
+// once for every dir and set of nixpkgs, so services that share a command do
+// not pay for it again.
+func (c *envComputer) sh(cmd string, env map[string]string) (string, error) {
+	if !c.procfile.computing {
+		return "", nil
+	}
+	key := strings.Join([]string{c.dir, strings.Join(c.nixpkgs, " "), fmt.Sprint(c.isolated), cmd}, "\x00")
+	if out, ok := c.procfile.computed[key]; ok {
+		return out, nil
+	}
+	keep := []string{}
+	for key := range env {
+		keep = append(keep, key)
+	}
+	sort.Strings(keep)
+	svc := &Service{procfile: c.procfile, Dir: c.dir, Nixpkgs: c.nixpkgs, Isolated: c.isolated}
+	proc := c.procfile.executor.Command(c.procfile.ctx, svc, cmd, keep)
+	proc.Dir = c.dir
+	// the whole group is killed when cancelled, since nix-shell runs the
+	// command in a child that would otherwise keep running.
+	proc.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	proc.Cancel = func() error { return syscall.Kill(-proc.Process.Pid, syscall.SIGKILL) }
+	if !c.isolated {
+		proc.Env = os.Environ()
+	}
+	for key, val := range env {
+		proc.Env = append(proc.Env, key+"="+val)
+	}
+	var stdout, stderr bytes.Buffer
+	proc.Stdout, proc.Stderr = &stdout, &stderr
+	if err := proc.Run(); err != nil {
+		if ctxErr := c.procfile.ctx.Err(); ctxErr != nil {
+			return "", fmt.Errorf("sh %q was stopped: %v", cmd, ctxErr)
+		} else if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("sh %q failed with %v: %v", cmd, err, msg)
+		}
+		return "", fmt.Errorf("sh %q failed with %v", cmd, err)
+	}
+	out := strings.TrimSpace(stdout.String())
+	c.procfile.computed[key] = out
+	return out, nil
+}
+
+// file reads the trimmed contents of a file. A leading ~ is the home dir, and
+// relative paths are relative to the dir.
+func (c *envComputer) file(path string) (string, error) {
+	if !c.procfile.computing {
+		return "", nil
+	}
+	if rest, ok := strings.CutPrefix(path, "~"); ok && (rest == "" || rest[0] == '/') {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = home + rest
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.dir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
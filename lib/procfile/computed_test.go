@@ -0,0 +1,132 @@
+package procfile
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+// hostExecutor runs sh values with sh on the host, recording the nixpkgs and
+// command of every call.
+type hostExecutor struct {
+	calls []string
+}
+
+func (host *hostExecutor) Command(ctx context.Context, svc *Service, shellCmd string, keep []string) *exec.Cmd {
+	host.calls = append(host.calls, strings.Join(svc.Nixpkgs, " ")+": "+shellCmd)
+	return exec.CommandContext(ctx, "sh", "-c", shellCmd)
+}
+
+func TestEnvValueYAML(t *testing.T) {
+	env := map[string]EnvValue{}
+	require.Nil(t, yaml.UnmarshalStrict([]byte("PORT: 8080\nSHA: {sh: git rev-parse HEAD}\nTOKEN: {file: ~/.token}\n"), &env))
+	assert.Equal(t, map[string]EnvValue{
+		"PORT":  {Value: "8080"},
+		"SHA":   {Sh: "git rev-parse HEAD"},
+		"TOKEN": {File: "~/.token"},
+	}, env)
+	data, err := yaml.Marshal(env)
+	require.Nil(t, err)
+	assert.Equal(t, "PORT: \"8080\"\nSHA:\n  sh: git rev-parse HEAD\nTOKEN:\n  file: ~/.token\n", string(data))
+
+	assert.EqualError(t, yaml.UnmarshalStrict([]byte("SHA: {sh: whoami, file: x}"), &env), "env value must set one of sh or file")
+	assert.NotNil(t, yaml.UnmarshalStrict([]byte("SHA: {run: whoami}"), &env))
+}
+
+func TestComputedEnv(t *testing.T) {
+	executor := &hostExecutor{}
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("GRIND_TEST_USER", "me")
+	require.Nil(t, os.Mkdir(filepath.Join(dir, "client"), 0o755))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, ".token"), []byte("tok-123\n"), 0o644))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "grind.yml"), []byte(`version: "1"
+nixpkgs: [git]
+env:
+  NAME: grind
+  GREETING: {sh: echo "hello $NAME $$GRIND_TEST_USER"}
+  TOKEN: {file: ~/.token}
+  URL: https://${TOKEN}@example.com
+services:
+  server:
+    cmds: [echo]
+    env:
+      SHA: {sh: echo abc123}
+  client:
+    dir: client
+    cmds: [echo]
+    env:
+      SHA: {sh: echo abc123}
+tasks:
+  build:
+    service: server
+    cmds: [echo]
+`), 0o644))
+	procfile, err := Parse(filepath.Join(dir, "grind.yml"))
+	require.Nil(t, err)
+	assert.Equal(t, "", procfile.Services["server"].Env["SHA"], "parsing does not run sh values")
+	for _, svc := range []*Service{procfile.Services["server"], procfile.Services["client"], procfile.Tasks["build"]} {
+		require.Nil(t, svc.ComputeEnv(context.Background(), executor))
+	}
+
+	server := procfile.Services["server"]
+	assert.Equal(t, "hello grind me", server.Env["GREETING"])
+	assert.Equal(t, "https://tok-123@example.com", server.Env["URL"])
+	assert.Equal(t, "abc123", server.Env["SHA"])
+	assert.Equal(t, "abc123", procfile.Tasks["build"].Env["SHA"])
+	assert.Equal(t, "abc123", procfile.Services["client"].Env["SHA"])
+
+	explained := map[string]EnvVar{}
+	for _, v := range server.Explain() {
+		explained[v.Key] = v
+	}
+	assert.Equal(t, "sh: echo abc123", explained["SHA"].Computed)
+	assert.Equal(t, "file: ~/.token", explained["TOKEN"].Computed)
+	assert.Equal(t, "", explained["NAME"].Computed)
+
+	assert.Equal(t, []string{
+		`git: echo "hello grind $GRIND_TEST_USER"`,
+		"git: echo abc123",
+		"git: echo abc123",
+	}, executor.calls, "the global sh, then the service sh once per dir")
+}
+
+func TestComputedEnvErrors(t *testing.T) {
+	dir := t.TempDir()
+	write := func(env string) error {
+		data := "version: \"1\"\nenv:\n  " + env + "\nservices:\n  server:\n    cmds: [echo]\n"
+		require.Nil(t, os.WriteFile(filepath.Join(dir, "grind.yml"), []byte(data), 0o644))
+		procfile, err := Parse(filepath.Join(dir, "grind.yml"))
+		require.Nil(t, err)
+		return procfile.Services["server"].ComputeEnv(context.Background(), &hostExecutor{})
+	}
+	assert.EqualError(t, write(`SHA: {sh: echo oops >&2; exit 3}`), `server: grind.yml:3: SHA: sh "echo oops >&2; exit 3" failed with exit status 3: oops`)
+	assert.ErrorContains(t, write(`TOKEN: {file: missing.txt}`), "server: grind.yml:3: TOKEN: open "+filepath.Join(dir, "missing.txt"))
+}
+
+func TestComputedEnvCancel(t *testing.T) {
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "grind.yml"), []byte(`version: "1"
+env:
+  SHA: {sh: sleep 10}
+services:
+  server:
+    cmds: [echo]
+`), 0o644))
+	procfile, err := Parse(filepath.Join(dir, "grind.yml"))
+	require.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = procfile.Services["server"].ComputeEnv(ctx, &hostExecutor{})
+	assert.ErrorContains(t, err, `sh "sleep 10" was stopped: context deadline exceeded`)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,21 @@
+// Package procfile loads a grind.yml into the model of a project that the rest
+// of grind runs. Parse, or ParseEnv to select an environment, reads the file,
+// resolves the env of every service and task through the env files, overlays,
+// and inherited services, and validates everything before it is returned.
+//
+// Services and tasks are both a *Service. After parsing, Env holds the resolved
+// env, Dir the absolute dir that commands run in, and Nixpkgs every package
+// that is needed, including the global ones and those of an inherited service.
+// Inherits returns the service that a task runs in the context of, Environ the
+// full env that commands see, and Explain where every value came from.
+//
+//	project, err := procfile.Parse("grind.yml")
+//	if err != nil {
+//		return err
+//	}
+//	task, err := project.Find("test")
+//	if err != nil {
+//		return err
+//	}
+//	fmt.Println(task.Dir, task.Env["DATABASE_URL"], task.Inherits().Name)
+package procfile
@@ -0,0 +1,454 @@
+package procfile
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	yaml3 "gopkg.in/yaml.v3"
+
+	"github.com/tanema/grind/lib/envfile"
+	"github.com/tanema/grind/lib/expand"
+	"github.com/tanema/grind/lib/secrets"
+)
+
+type (
+	// EnvVar is a variable in the environment of a service along with the source
+	// that it was defined in, and the sources of the values that it overrides.
+	EnvVar struct {
+		Key       string
+		Value     string
+		Source    string
+		Overrides []string
+		Secret    bool
+		// Computed describes the sh or file that computed the value, if any
+		Computed string
+	}
+	// envLayer is the variables from a single source, like an env file or the
+	// env of a service in the grind.yml.
+	envLayer map[string]EnvVar
+	// envStack is an ordered list of layers where later layers take precedence.
+	// From lowest to highest precedence the layers are:
+	//  1. the defaults from the env_schema, for variables the host does not set
+	//  2. the host environment, unless the service is isolated
+	//  3. the envs files of the grind.yml, in order
+	//  4. the env of the grind.yml
+	//  5. the envs files, then env, of the service that a task inherits
+	//  6. the envs files of the service or task, in order
+	//  7. the env of the service or task
+	//  8. the SVC and TASK variables set by grind
+	envStack []envLayer
+)
+
+// hostSource is the source of variables inherited from the host environment
+const hostSource = "host"
+
+// secretPrefix marks a value in env or an env file as a secret, like
+// API_KEY: secret:abc123
+const secretPrefix = "secret:"
+
+// Environ will generate a sorted array of the variables for a single service,
+// with every key set once. The env of the service is layered on top of the host
+// environment, unless the service is isolated.
+func (svc *Service) Environ() []string {
+	env := svc.environ()
+	keys := []string{}
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+env[key])
+	}
+	return pairs
+}
+
+// environ is the same as Environ as a map
+func (svc *Service) environ() map[string]string {
+	env := map[string]string{}
+	if !svc.Isolated {
+		for _, pair := range os.Environ() {
+			key, val, _ := strings.Cut(pair, "=")
+			env[key] = val
+		}
+	}
+	for key, val := range svc.Env {
+		env[key] = val
+	}
+	return env
+}
+
+// EnvKeys will collect all the env keys that are set for the service. This is
+// used for isolated shells to tell nix-shell to keep those values
+func (svc *Service) EnvKeys() []string {
+	keys := []string{}
+	for key := range svc.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// IsSecret checks if a variable is marked as a secret, either by the
+// env_schema, a secret: prefix, or by coming from an encrypted env file.
+func (svc *Service) IsSecret(key string) bool {
+	for _, spec := range svc.procfile.EnvSchema {
+		if spec.Name == key && spec.Secret {
+			return true
+		}
+	}
+	v, ok := svc.layers.lookup(key)
+	return ok && v.Secret
+}
+
+// Secrets returns the values of every secret in the env of the service so that
+// they can be redacted from output.
+func (svc *Service) Secrets() []string {
+	values := []string{}
+	for _, pair := range svc.Environ() {
+		key, val, _ := strings.Cut(pair, "=")
+		if val != "" && svc.IsSecret(key) {
+			values = append(values, val)
+		}
+	}
+	return values
+}
+
+// Explain returns every variable in the environment of the service, sorted by
+// name, with the source of its final value and the sources it overrides.
+func (svc *Service) Explain() []EnvVar {
+	vars := svc.layers.resolve()
+	if !svc.Isolated {
+		for _, pair := range os.Environ() {
+			key, val, _ := strings.Cut(pair, "=")
+			if v, ok := vars[key]; ok {
+				v.Overrides = append([]string{hostSource}, v.Overrides...)
+				vars[key] = v
+			} else {
+				vars[key] = EnvVar{Key: key, Value: val, Source: hostSource}
+			}
+		}
+	}
+	explained := []EnvVar{}
+	for _, v := range vars {
+		explained = append(explained, v)
+	}
+	sort.Slice(explained, func(i, j int) bool { return explained[i].Key < explained[j].Key })
+	return explained
+}
+
+// EnvFiles lists every env file that the environment of the service is read
+// from, in the order that they are layered.
+func (svc *Service) EnvFiles() []string {
+	files := svc.procfile.envFiles(svc.environment)
+	if svc.service != nil {
+		files = append(files, svc.service.envFiles(svc.environment)...)
+	}
+	return append(files, svc.envFiles(svc.environment)...)
+}
+
+// setupEnv builds the env layers of a service on top of the layers of the
+// procfile and the service it inherits, for the selected environment or the
+// one that the service forces.
+func (svc *Service) setupEnv() error {
+	svc.environment = svc.procfile.Environment
+	if svc.Environment != "" {
+		svc.environment = svc.Environment
+	}
+	stack, err := svc.procfile.baseLayers(svc.environment)
+	if err != nil {
+		return err
+	}
+	if svc.service != nil {
+		if stack, err = svc.service.ownLayers(stack, svc.environment); err != nil {
+			return err
+		}
+	}
+	if stack, err = svc.ownLayers(stack, svc.environment); err != nil {
+		return err
+	}
+	grind := envLayer{"SVC": {Key: "SVC", Value: svc.Name, Source: "grind"}}
+	if svc.IsTask {
+		grind["SVC"] = EnvVar{Key: "SVC", Value: svc.Service, Source: "grind"}
+		grind["TASK"] = EnvVar{Key: "TASK", Value: svc.Name, Source: "grind"}
+	}
+	if svc.environment != "" {
+		grind[EnvironmentVar] = EnvVar{Key: EnvironmentVar, Value: svc.environment, Source: "grind"}
+	}
+	svc.layers = append(stack, grind)
+	svc.Env = svc.layers.env()
+	return nil
+}
+
+// ownLayers adds the envs files and env of the service on top of stack
+func (svc *Service) ownLayers(stack envStack, environment string) (envStack, error) {
+	kind := "services"
+	if svc.IsTask {
+		kind = "tasks"
+	}
+	stack, err := stack.withFiles(svc.envFiles(environment), svc.computer())
+	if err != nil {
+		return nil, err
+	}
+	prefix := fmt.Sprintf("%v.%v.", kind, svc.Name)
+	return stack.withOverlay(svc.RawEnv, svc.overlays.env[environment], environment, svc.procfile.source, prefix, svc.Matrix, svc.computer())
+}
+
+func (svc *Service) envFiles(environment string) []string {
+	files := append(append([]string{}, svc.Envfiles...), svc.overlays.files[environment]...)
+	if svc.Dir != svc.procfile.Dir {
+		files = append(files, conventionFile(svc.Dir, environment)...)
+	}
+	return nonEmpty(files)
+}
+
+func (procfile *Procfile) setupEnv(data []byte) error {
+	procfile.lines = yamlLines(data)
+	procfile.keys = &secrets.Keys{Dir: procfile.Dir}
+	procfile.envLayers = map[string]envStack{}
+	procfile.computed = map[string]string{}
+	procfile.skipped = map[string]bool{}
+	for _, spec := range procfile.EnvSchema {
+		if err := spec.setup(procfile); err != nil {
+			return err
+		}
+	}
+	overlays, err := parseOverlays(procfile.Overlays, filepath.Base(procfile.Filepath))
+	procfile.overlays = overlays
+	return err
+}
+
+// baseLayers builds the layers from the grind.yml that every service and task
+// is built on, for an environment.
+func (procfile *Procfile) baseLayers(environment string) (envStack, error) {
+	if stack, ok := procfile.envLayers[environment]; ok {
+		return stack, nil
+	}
+	stack, err := envStack{procfile.schemaLayer()}.withFiles(procfile.envFiles(environment), procfile.computer())
+	if err != nil {
+		return nil, err
+	}
+	stack, err = stack.withOverlay(procfile.Env, procfile.overlays.env[environment], environment, procfile.source, "", nil, procfile.computer())
+	if err != nil {
+		return nil, err
+	}
+	procfile.envLayers[environment] = stack
+	return stack, nil
+}
+
+func (procfile *Procfile) envFiles(environment string) []string {
+	files := append(append([]string{}, procfile.Envfiles...), procfile.overlays.files[environment]...)
+	return nonEmpty(append(files, conventionFile(procfile.Dir, environment)...))
+}
+
+// source describes where a key in the grind.yml is, like grind.yml:12
+func (procfile *Procfile) source(path string) string {
+	name := filepath.Base(procfile.Filepath)
+	if line, ok := procfile.lines[path]; ok {
+		return fmt.Sprintf("%v:%v", name, line)
+	}
+	return name
+}
+
+// withFiles returns a new stack with a layer added for every env file. The
+// values in each file can reference the variables in the layers below it.
+// Encrypted files are only decrypted once the env is computed, and all of their
+// values are secrets.
+func (stack envStack) withFiles(files []string, computer *envComputer) (envStack, error) {
+	stack = stack.copy()
+	for _, file := range files {
+		if file == "" || computer.skip(file) {
+			continue
+		}
+		data, encrypted, err := computer.procfile.keys.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		vars, err := envfile.Decode(file, bytes.NewReader(data), stack.env())
+		if err != nil {
+			return nil, err
+		}
+		layer := envLayer{}
+		for _, v := range vars {
+			val, secret := strings.CutPrefix(v.Value, secretPrefix)
+			layer[v.Key] = EnvVar{Key: v.Key, Value: val, Source: fmt.Sprintf("%v:%v", file, v.Line), Secret: secret || encrypted}
+		}
+		stack = append(stack, layer)
+	}
+	return stack, nil
+}
+
+// withOverlay adds the env of the grind.yml or a service, then the env.<name>
+// overlay of the environment on top of it. Keys are looked up in the grind.yml
+// under the path prefix for sources.
+func (stack envStack) withOverlay(env, overlay map[string]EnvValue, environment string, source func(string) string, prefix string, matrix map[string][]string, computer *envComputer) (envStack, error) {
+	stack, err := stack.withEnv(env, func(key string) string { return source(prefix + "env." + key) }, matrix, computer)
+	if err != nil || len(overlay) == 0 {
+		return stack, err
+	}
+	return stack.withEnv(overlay, func(key string) string { return source(prefix + "env." + environment + "." + key) }, matrix, computer)
+}
+
+// withEnv returns a new stack with a layer added for env values from the
+// grind.yml. Values can reference each other, or the layers below. A value
+// that references itself, like PATH: $PATH:./bin, gets the value from below.
+// References to matrix values are kept so that they can be expanded for each
+// instance of the task. Computed values are run or read with the computer.
+func (stack envStack) withEnv(raw map[string]EnvValue, source func(string) string, matrix map[string][]string, computer *envComputer) (envStack, error) {
+	layer := envLayer{}
+	assigned := map[string]string{}
+	resolving := map[string]bool{}
+	var get func(key string) (string, error)
+	get = func(key string) (string, error) {
+		if v, ok := layer[key]; ok {
+			return v.Value, nil
+		}
+		resolving[key] = true
+		defer delete(resolving, key)
+		var refErr error
+		value := raw[key]
+		expander := expand.Expander{
+			Lookup: func(name string) (string, bool) {
+				if _, ok := matrix[name]; ok {
+					return "${" + name + "}", true
+				} else if val, ok := assigned[name]; ok {
+					return val, true
+				} else if _, ok := raw[name]; ok && !resolving[name] {
+					val, err := get(name)
+					if err != nil && refErr == nil {
+						refErr = err
+					}
+					return val, true
+				} else if v, ok := stack.lookup(name); ok {
+					return v.Value, true
+				}
+				return os.LookupEnv(name)
+			},
+			Assign: func(name, val string) { assigned[name] = val },
+		}
+		val, err := stack.compute(value, expander, computer)
+		if refErr != nil {
+			return "", refErr
+		} else if err != nil {
+			return "", fmt.Errorf("%v: %v: %v", source(key), key, err)
+		}
+		val, secret := strings.CutPrefix(val, secretPrefix)
+		v := EnvVar{Key: key, Value: val, Source: source(key), Secret: secret}
+		if value.IsComputed() {
+			v.Computed = value.String()
+		}
+		layer[key] = v
+		return val, nil
+	}
+	keys := []string{}
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, err := get(key); err != nil {
+			return nil, err
+		}
+	}
+	return append(stack.copy(), layer), nil
+}
+
+// compute expands a plain value, or runs or reads a computed one. Commands and
+// file paths are expanded like a plain value first.
+func (stack envStack) compute(value EnvValue, expander expand.Expander, computer *envComputer) (string, error) {
+	if value.IsComputed() && computer == nil {
+		return "", fmt.Errorf("%v cannot be computed here", value)
+	} else if value.Sh != "" {
+		cmd, err := expander.Expand(value.Sh)
+		if err != nil {
+			return "", err
+		}
+		return computer.sh(cmd, stack.env())
+	} else if value.File != "" {
+		path, err := expander.Expand(value.File)
+		if err != nil {
+			return "", err
+		}
+		return computer.file(path)
+	}
+	return expander.Expand(value.Value)
+}
+
+// hasComputed checks if any variable in the stack is a computed value
+func (stack envStack) hasComputed() bool {
+	for _, layer := range stack {
+		for _, v := range layer {
+			if v.Computed != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (stack envStack) lookup(name string) (EnvVar, bool) {
+	for i := len(stack) - 1; i >= 0; i-- {
+		if v, ok := stack[i][name]; ok {
+			return v, true
+		}
+	}
+	return EnvVar{}, false
+}
+
+// resolve collapses the stack into the final value of every variable
+func (stack envStack) resolve() map[string]EnvVar {
+	vars := map[string]EnvVar{}
+	for _, layer := range stack {
+		for key, v := range layer {
+			if prev, ok := vars[key]; ok {
+				v.Overrides = append(append([]string{}, prev.Overrides...), prev.Source)
+			}
+			vars[key] = v
+		}
+	}
+	return vars
+}
+
+func (stack envStack) env() map[string]string {
+	env := map[string]string{}
+	for key, v := range stack.resolve() {
+		env[key] = v.Value
+	}
+	return env
+}
+
+func (stack envStack) copy() envStack {
+	return append(envStack{}, stack...)
+}
+
+// yamlLines finds the line of every key in the grind.yml by its dotted path,
+// like services.server.env.PORT, so that env values can point to their source.
+func yamlLines(data []byte) map[string]int {
+	lines := map[string]int{}
+	var doc yaml3.Node
+	if err := yaml3.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 {
+		return lines
+	}
+	var walk func(node *yaml3.Node, path string)
+	walk = func(node *yaml3.Node, path string) {
+		if node.Kind == yaml3.SequenceNode {
+			for i, item := range node.Content {
+				walk(item, fmt.Sprintf("%v%v.", path, i))
+			}
+			return
+		} else if node.Kind != yaml3.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := path + node.Content[i].Value
+			lines[key] = node.Content[i].Line
+			walk(node.Content[i+1], key+".")
+		}
+	}
+	walk(doc.Content[0], "")
+	return lines
+}
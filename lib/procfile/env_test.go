@@ -0,0 +1,180 @@
+package procfile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tanema/grind/lib/secrets"
+)
+
+func TestEnvStackExpansion(t *testing.T) {
+	t.Setenv("GRIND_TEST_PATH", "/usr/bin")
+	source := func(key string) string { return "grind.yml" }
+	base := envStack{{"HOST": {Key: "HOST", Value: "localhost"}}}
+	stack, err := base.withEnv(map[string]EnvValue{
+		"GRIND_TEST_PATH": {Value: "${GRIND_TEST_PATH}:./bin"},
+		"URL":             {Value: "postgres://${HOST}:${PORT}/${db}"},
+		"PORT":            {Value: "${PORT:-5432}"},
+	}, source, map[string][]string{"db": {"a", "b"}}, nil)
+	require.Nil(t, err)
+	assert.Len(t, base, 1)
+	env := stack.env()
+	assert.Equal(t, "/usr/bin:./bin", env["GRIND_TEST_PATH"])
+	assert.Equal(t, "postgres://localhost:5432/${db}", env["URL"])
+
+	_, err = base.withEnv(map[string]EnvValue{"URL": {Value: "postgres://${DB_HOST:?set the db host}"}}, source, nil, nil)
+	assert.EqualError(t, err, "grind.yml: URL: DB_HOST: set the db host")
+}
+
+func TestEnvPrecedence(t *testing.T) {
+	t.Setenv("GRIND_TEST_HOST", "host")
+	dir := t.TempDir()
+	write := func(name, data string) {
+		require.Nil(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644))
+	}
+	write("global.env", "GRIND_TEST_HOST=global-file\nLEVEL=global-file\n")
+	write("server.env", "FROM_FILE=$LEVEL\nLEVEL=server-file\n")
+	write("task.env", "LEVEL=task-file\n")
+	write("grind.yml", `version: "1"
+envs: [global.env]
+env:
+  LEVEL: global
+services:
+  server:
+    envs: [server.env]
+    env:
+      LEVEL: server
+      PORT: "8080"
+tasks:
+  deploy:
+    service: server
+    envs: [task.env]
+    env:
+      LEVEL: ${LEVEL}-task
+`)
+	wd, err := os.Getwd()
+	require.Nil(t, err)
+	require.Nil(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(wd) })
+	procfile, err := Parse("grind.yml")
+	require.Nil(t, err)
+
+	server := procfile.Services["server"]
+	assert.Equal(t, "server", server.Env["LEVEL"])
+	assert.Equal(t, "global", server.Env["FROM_FILE"])
+	assert.Equal(t, "global-file", server.Env["GRIND_TEST_HOST"])
+	hosts := []string{}
+	for _, pair := range server.Environ() {
+		if strings.HasPrefix(pair, "GRIND_TEST_HOST=") {
+			hosts = append(hosts, pair)
+		}
+	}
+	assert.Equal(t, []string{"GRIND_TEST_HOST=global-file"}, hosts, "every key is only set once")
+
+	deploy := procfile.Tasks["deploy"]
+	assert.Equal(t, "task-file-task", deploy.Env["LEVEL"])
+	assert.Equal(t, "8080", deploy.Env["PORT"])
+	assert.Equal(t, "server", deploy.Env["SVC"])
+	assert.Equal(t, "deploy", deploy.Env["TASK"])
+
+	explained := map[string]EnvVar{}
+	for _, v := range deploy.Explain() {
+		explained[v.Key] = v
+	}
+	assert.Equal(t, EnvVar{
+		Key:    "LEVEL",
+		Value:  "task-file-task",
+		Source: "grind.yml:16",
+		Overrides: []string{
+			"global.env:2",
+			"grind.yml:4",
+			"server.env:2",
+			"grind.yml:9",
+			"task.env:1",
+		},
+	}, explained["LEVEL"])
+	assert.Equal(t, "grind.yml:10", explained["PORT"].Source)
+	assert.Equal(t, "grind", explained["TASK"].Source)
+	assert.Equal(t, []string{"host"}, explained["GRIND_TEST_HOST"].Overrides)
+}
+
+func TestEnvSecrets(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(secrets.PassphraseEnv, "")
+	t.Setenv(secrets.IdentityEnv, filepath.Join(dir, "key.txt"))
+	encrypted, err := (&secrets.Keys{Dir: dir}).Encrypt([]byte("API_KEY=sk-abcdef\n"))
+	require.Nil(t, err)
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "secrets.env.enc"), encrypted, 0o644))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "grind.yml"), []byte(`version: "1"
+envs: [`+filepath.Join(dir, "secrets.env.enc")+`]
+env_schema:
+  - name: DB_PASS
+    secret: true
+env:
+  DB_PASS: hunter22
+  TOKEN: secret:tok-12345
+  PORT: "8080"
+services:
+  server:
+    cmds: [echo]
+`), 0o644))
+	procfile, err := Parse(filepath.Join(dir, "grind.yml"))
+	require.Nil(t, err)
+	server := procfile.Services["server"]
+	assert.Equal(t, "", server.Env["API_KEY"], "parsing does not decrypt env files")
+	require.Nil(t, server.ComputeEnv(context.Background(), nil))
+	assert.Equal(t, "sk-abcdef", server.Env["API_KEY"])
+	assert.Equal(t, "tok-12345", server.Env["TOKEN"])
+	for _, key := range []string{"API_KEY", "DB_PASS", "TOKEN"} {
+		assert.True(t, server.IsSecret(key), key)
+	}
+	assert.False(t, server.IsSecret("PORT"))
+	assert.ElementsMatch(t, []string{"sk-abcdef", "hunter22", "tok-12345"}, server.Secrets())
+}
+
+func TestEnvSecretsWithoutKey(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(secrets.PassphraseEnv, "")
+	t.Setenv(secrets.IdentityEnv, filepath.Join(dir, "key.txt"))
+	encrypted, err := (&secrets.Keys{Dir: dir}).Encrypt([]byte("API_KEY=sk-abcdef\n"))
+	require.Nil(t, err)
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "secrets.env.enc"), encrypted, 0o644))
+	require.Nil(t, os.Remove(filepath.Join(dir, "key.txt")))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "grind.yml"), []byte(`version: "1"
+envs: [`+filepath.Join(dir, "secrets.env.enc")+`]
+services:
+  server:
+    envs: [`+filepath.Join(dir, "new.env.enc")+`]
+    cmds: [echo]
+`), 0o644))
+	procfile, err := Parse(filepath.Join(dir, "grind.yml"))
+	require.Nil(t, err, "parsing does not need the key, or the .enc files to exist")
+	assert.ErrorContains(t, procfile.Services["server"].ComputeEnv(context.Background(), nil), "no key to decrypt secrets")
+}
+
+func TestParseDoesNotDecrypt(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(secrets.PassphraseEnv, "correct horse battery staple")
+	encrypted, err := (&secrets.Keys{Dir: dir}).Encrypt([]byte("API_KEY=sk-abcdef\n"))
+	require.Nil(t, err)
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "secrets.env.enc"), encrypted, 0o644))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "grind.yml"), []byte(`version: "1"
+envs: [`+filepath.Join(dir, "secrets.env.enc")+`]
+env:
+  SHA: {sh: echo abc123}
+services:
+  server:
+    cmds: [echo]
+`), 0o644))
+	t.Setenv(secrets.PassphraseEnv, "wrong")
+	procfile, err := Parse(filepath.Join(dir, "grind.yml"))
+	require.Nil(t, err, "the key is not used while parsing")
+	assert.Equal(t, "", procfile.Services["server"].Env["SHA"], "parsing does not run sh values")
+	assert.Equal(t, "", procfile.Services["server"].Env["API_KEY"])
+}
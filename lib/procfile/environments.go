@@ -0,0 +1,124 @@
+package procfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvironmentVar selects the environment when --env is not passed, and is set
+// to the selected environment for every command.
+const EnvironmentVar = "GRIND_ENV"
+
+// overlays are the envs.<name> and env.<name> keys of the grind.yml or a
+// service, which are only layered on when that environment is selected.
+type overlays struct {
+	files map[string][]string
+	env   map[string]map[string]EnvValue
+}
+
+func parseOverlays(raw map[string]any, where string) (overlays, error) {
+	parsed := overlays{files: map[string][]string{}, env: map[string]map[string]EnvValue{}}
+	for key, val := range raw {
+		kind, name, _ := strings.Cut(key, ".")
+		if name == "" || (kind != "envs" && kind != "env") {
+			return parsed, fmt.Errorf("%v has unknown field %v", where, key)
+		}
+		data, err := yaml.Marshal(val)
+		if err != nil {
+			return parsed, err
+		}
+		if kind == "envs" {
+			files := []string{}
+			if err := yaml.UnmarshalStrict(data, &files); err != nil {
+				return parsed, fmt.Errorf("%v %v must be a list of env files", where, key)
+			}
+			parsed.files[name] = files
+		} else {
+			env := map[string]EnvValue{}
+			if err := yaml.UnmarshalStrict(data, &env); err != nil {
+				return parsed, fmt.Errorf("%v %v must be a map of env vars", where, key)
+			}
+			parsed.env[name] = env
+		}
+	}
+	return parsed, nil
+}
+
+func (o overlays) names() []string {
+	names := []string{}
+	for name := range o.files {
+		names = append(names, name)
+	}
+	for name := range o.env {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Environments lists every environment that the grind.yml knows about, from
+// the envs.<name> and env.<name> overlays, the environments that tasks force,
+// and the .env.<name> files next to the grind.yml.
+func (procfile *Procfile) Environments() []string {
+	names := procfile.overlays.names()
+	for _, svc := range procfile.all() {
+		names = append(names, svc.overlays.names()...)
+		if svc.Environment != "" {
+			names = append(names, svc.Environment)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(procfile.Dir, ".env.*"))
+	for _, file := range files {
+		// skip templates like .env.example and encrypted files like .env.prod.enc
+		if name := strings.TrimPrefix(filepath.Base(file), ".env."); name != "example" && !strings.Contains(name, ".") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return slices.Compact(names)
+}
+
+func (procfile *Procfile) checkEnvironment() error {
+	if procfile.Environment == "" || slices.Contains(procfile.Environments(), procfile.Environment) {
+		return nil
+	}
+	return fmt.Errorf("unknown environment %v, expected one of: %v", procfile.Environment, strings.Join(procfile.Environments(), ", "))
+}
+
+func (procfile *Procfile) all() []*Service {
+	all := []*Service{}
+	for _, svc := range procfile.Services {
+		all = append(all, svc)
+	}
+	for _, task := range procfile.Tasks {
+		all = append(all, task)
+	}
+	return all
+}
+
+// conventionFile finds the .env.<name> file for an environment in a dir
+func conventionFile(dir, environment string) []string {
+	if environment == "" {
+		return nil
+	}
+	path := filepath.Join(dir, ".env."+environment)
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	return []string{path}
+}
+
+func nonEmpty(files []string) []string {
+	kept := []string{}
+	for _, file := range files {
+		if file != "" {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,89 @@
+package procfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvironments(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		require.Nil(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644))
+	}
+	write(".env.staging", "LEVEL=staging-file\nFROM_FILE=1\n")
+	write(".env.example", "LEVEL=\n")
+	write("prod.env", "LEVEL=prod-file\nDEBUG=false\n")
+	write("grind.yml", `version: "1"
+env:
+  LEVEL: default
+envs.prod: [prod.env]
+env.staging:
+  LEVEL: staging
+services:
+  server:
+    cmds: [echo]
+    env:
+      PORT: "8080"
+    env.test:
+      PORT: "9090"
+tasks:
+  test:
+    service: server
+    environment: test
+    cmds: [echo]
+`)
+	wd, err := os.Getwd()
+	require.Nil(t, err)
+	require.Nil(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(wd) })
+	path := "grind.yml"
+
+	procfile, err := ParseEnv(path, "")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"prod", "staging", "test"}, procfile.Environments())
+	server := procfile.Services["server"]
+	assert.Equal(t, "default", server.Env["LEVEL"])
+	assert.Equal(t, "8080", server.Env["PORT"])
+	assert.NotContains(t, server.Env, EnvironmentVar)
+	test := procfile.Tasks["test"]
+	assert.Equal(t, "9090", test.Env["PORT"])
+	assert.Equal(t, "test", test.Env[EnvironmentVar])
+
+	procfile, err = ParseEnv(path, "staging")
+	require.Nil(t, err)
+	server = procfile.Services["server"]
+	assert.Equal(t, "staging", server.Env["LEVEL"])
+	assert.Equal(t, "1", server.Env["FROM_FILE"])
+	assert.Equal(t, "staging", server.Env[EnvironmentVar])
+	assert.Equal(t, "test", procfile.Tasks["test"].Env[EnvironmentVar])
+
+	procfile, err = ParseEnv(path, "prod")
+	require.Nil(t, err)
+	server = procfile.Services["server"]
+	assert.Equal(t, "default", server.Env["LEVEL"])
+	assert.Equal(t, "false", server.Env["DEBUG"])
+
+	_, err = ParseEnv(path, "qa")
+	assert.EqualError(t, err, "unknown environment qa, expected one of: prod, staging, test")
+}
+
+func TestParseOverlays(t *testing.T) {
+	parsed, err := parseOverlays(map[string]any{
+		"envs.prod":   []any{"prod.env"},
+		"env.staging": map[string]any{"LEVEL": "staging"},
+	}, "grind.yml")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"prod.env"}, parsed.files["prod"])
+	assert.Equal(t, map[string]EnvValue{"LEVEL": {Value: "staging"}}, parsed.env["staging"])
+
+	_, err = parseOverlays(map[string]any{"foo.bar": "baz"}, "grind.yml")
+	assert.EqualError(t, err, "grind.yml has unknown field foo.bar")
+	_, err = parseOverlays(map[string]any{"envs.prod": "prod.env"}, "grind.yml")
+	assert.EqualError(t, err, "grind.yml envs.prod must be a list of env files")
+	_, err = parseOverlays(map[string]any{"env.prod": []any{"A=1"}}, "grind.yml")
+	assert.EqualError(t, err, "grind.yml env.prod must be a map of env vars")
+}
@@ -0,0 +1,44 @@
+package procfile_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tanema/grind/lib/procfile"
+)
+
+func ExampleParse() {
+	dir, err := os.MkdirTemp("", "grind-example")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	config := `version: "1"
+nixpkgs: [git]
+services:
+  server:
+    dir: server
+    nixpkgs: [go]
+    env:
+      PORT: "8080"
+tasks:
+  test:
+    service: server
+    cmds: [go test ./...]
+`
+	if err := os.WriteFile(filepath.Join(dir, "grind.yml"), []byte(config), 0o644); err != nil {
+		panic(err)
+	}
+	project, err := procfile.Parse(filepath.Join(dir, "grind.yml"))
+	if err != nil {
+		panic(err)
+	}
+	task, err := project.Find("test")
+	if err != nil {
+		panic(err)
+	}
+	rel, _ := filepath.Rel(dir, task.Dir)
+	fmt.Println(task.Inherits().Name, rel, task.Env["PORT"], task.Env["TASK"], task.Nixpkgs)
+	// Output: server server 8080 test [git go]
+}
@@ -0,0 +1,95 @@
+package procfile
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// MatrixKeys returns the names of the matrix dimensions in a stable order
+func (svc *Service) MatrixKeys() []string {
+	keys := []string{}
+	for key := range svc.Matrix {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Expand creates a task instance for every combination of values in the matrix.
+// Each instance has its nixpkgs and env templated with the values of the
+// combination, which are also set in MatrixValues so that they can be used
+// like args.
+func (svc *Service) Expand() []*Service {
+	keys := svc.MatrixKeys()
+	combos := []map[string]string{{}}
+	for _, key := range keys {
+		next := []map[string]string{}
+		for _, combo := range combos {
+			for _, val := range svc.Matrix[key] {
+				expanded := map[string]string{key: val}
+				for k, v := range combo {
+					expanded[k] = v
+				}
+				next = append(next, expanded)
+			}
+		}
+		combos = next
+	}
+	instances := []*Service{}
+	for _, combo := range combos {
+		inst := *svc
+		vals := []string{}
+		for _, key := range keys {
+			vals = append(vals, combo[key])
+		}
+		inst.Name = fmt.Sprintf("%v[%v]", svc.Name, strings.Join(vals, ","))
+		inst.Matrix = nil
+		inst.MatrixValues = combo
+		inst.Nixpkgs = []string{}
+		for _, pkg := range svc.Nixpkgs {
+			inst.Nixpkgs = append(inst.Nixpkgs, expandMatrix(pkg, combo))
+		}
+		inst.Env = map[string]string{}
+		for key, val := range svc.Env {
+			inst.Env[key] = replaceMatrix(val, combo)
+		}
+		instances = append(instances, &inst)
+	}
+	return instances
+}
+
+// expandMatrix replaces references to matrix values in the nixpkgs, leaving
+// every other variable in place to be expanded when the command runs.
+func expandMatrix(str string, combo map[string]string) string {
+	return os.Expand(str, func(key string) string {
+		if val, ok := combo[key]; ok {
+			return val
+		}
+		return "${" + key + "}"
+	})
+}
+
+// replaceMatrix replaces the ${name} placeholders that are kept for matrix
+// values when the env is resolved. The rest of the value is already resolved so
+// it is left as it is, even if it contains a $.
+func replaceMatrix(str string, combo map[string]string) string {
+	for key, val := range combo {
+		str = strings.ReplaceAll(str, "${"+key+"}", val)
+	}
+	return str
+}
+
+func (svc *Service) setupMatrix(seen map[string]bool) error {
+	for _, key := range svc.MatrixKeys() {
+		if !argNamePattern.MatchString(key) {
+			return fmt.Errorf("task %v has an invalid matrix name %q", svc.Name, key)
+		} else if seen[key] {
+			return fmt.Errorf("task %v declares %v more than once", svc.Name, key)
+		} else if len(svc.Matrix[key]) == 0 {
+			return fmt.Errorf("task %v matrix %v has no values", svc.Name, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package procfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandMatrix(t *testing.T) {
+	task := &Service{
+		Name:    "test",
+		Nixpkgs: []string{"${go}", "git"},
+		Env:     map[string]string{"DB": "${db}", "HOME": "${HOME}", "PRICE": "$5", "V": "ver-${go}"},
+		Matrix:  map[string][]string{"go": {"go_1_21", "go_1_22"}, "db": {"mysql", "postgresql"}},
+	}
+	assert.Nil(t, task.setupArgs())
+	assert.Equal(t, []string{"db", "go"}, task.MatrixKeys())
+
+	instances := task.Expand()
+	names := []string{}
+	for _, inst := range instances {
+		names = append(names, inst.Name)
+	}
+	assert.Equal(t, []string{
+		"test[mysql,go_1_21]",
+		"test[mysql,go_1_22]",
+		"test[postgresql,go_1_21]",
+		"test[postgresql,go_1_22]",
+	}, names)
+	assert.Equal(t, []string{"go_1_22", "git"}, instances[3].Nixpkgs)
+	assert.Equal(t, map[string]string{"DB": "postgresql", "HOME": "${HOME}", "PRICE": "$5", "V": "ver-go_1_22"}, instances[3].Env)
+	assert.Equal(t, map[string]string{"db": "postgresql", "go": "go_1_22"}, instances[3].MatrixValues)
+	assert.Equal(t, "${db}", task.Env["DB"])
+}
+
+func TestSetupMatrix(t *testing.T) {
+	bad := []*Service{
+		{Name: "t", Matrix: map[string][]string{"1go": {"go"}}},
+		{Name: "t", Matrix: map[string][]string{"go": {}}},
+		{Name: "t", Args: []*Arg{{Name: "go"}}, Matrix: map[string][]string{"go": {"go"}}},
+	}
+	for _, task := range bad {
+		assert.NotNil(t, task.setupArgs())
+	}
+}
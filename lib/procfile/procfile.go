@@ -1,57 +1,93 @@
 package procfile
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 
+	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v2"
 
-	"github.com/tanema/grind/lib/envfile"
+	"github.com/tanema/grind/lib/secrets"
 )
 
 type (
 	// Procfile is the type for the procfile definition
 	Procfile struct {
-		Dir      string              `yaml:"-"`
-		Filepath string              `yaml:"-"`
-		Version  string              `yaml:"version"`
-		Envfiles []string            `yaml:"envs,omitempty"`
-		Env      map[string]string   `yaml:"env,omitempty"`
-		Nixpkgs  []string            `yaml:"nixpkgs,omitempty"`
-		Services map[string]*Service `yaml:"services,omitempty"`
-		Tasks    map[string]*Service `yaml:"tasks,omitempty"`
+		Dir       string              `yaml:"-"`
+		Filepath  string              `yaml:"-"`
+		Version   string              `yaml:"version"`
+		Envfiles  []string            `yaml:"envs,omitempty"`
+		Env       map[string]EnvValue `yaml:"env,omitempty"`
+		EnvSchema []*EnvSpec          `yaml:"env_schema,omitempty"`
+		Nixpkgs   []string            `yaml:"nixpkgs,omitempty"`
+		Services  map[string]*Service `yaml:"services,omitempty"`
+		Tasks     map[string]*Service `yaml:"tasks,omitempty"`
+		Overlays  map[string]any      `yaml:",inline"`
+		// Environment is the selected environment, like staging
+		Environment string `yaml:"-"`
+		overlays    overlays
+		envLayers   map[string]envStack
+		lines       map[string]int
+		keys        *secrets.Keys
+		computed    map[string]string
+		skipped     map[string]bool
+		computing   bool
+		ctx         context.Context
+		executor    Executor
+		mut         sync.Mutex
 	}
 	// Service is a single process description
 	Service struct {
-		procfile    *Procfile         `yaml:"-"`
-		Hidden      bool              `yaml:"hidden,omitempty"`
-		Name        string            `yaml:"-"`
-		Usage       string            `yaml:"usage,omitempty"`
-		Nixpkgs     []string          `yaml:"nixpkgs,omitempty"`
-		Isolated    bool              `yaml:"isolated,omitempty"`
-		IsTask      bool              `yaml:"-"`
-		Description string            `yaml:"desc,omitempty"`
-		Service     string            `yaml:"service,omitempty"`
-		service     *Service          `yaml:"-"`
-		Envfiles    []string          `yaml:"envs,omitempty"`
-		Dir         string            `yaml:"dir,omitempty"`
-		Env         map[string]string `yaml:"env,omitempty"`
-		Before      []string          `yaml:"before,omitempty"`
-		Cmd         []string          `yaml:"cmds,omitempty"`
-		After       []string          `yaml:"after,omitempty"`
+		procfile     *Procfile           `yaml:"-"`
+		Hidden       bool                `yaml:"hidden,omitempty"`
+		Name         string              `yaml:"-"`
+		Usage        string              `yaml:"usage,omitempty"`
+		Nixpkgs      []string            `yaml:"nixpkgs,omitempty"`
+		Isolated     bool                `yaml:"isolated,omitempty"`
+		TTY          bool                `yaml:"tty,omitempty"`
+		IsTask       bool                `yaml:"-"`
+		Description  string              `yaml:"desc,omitempty"`
+		Service      string              `yaml:"service,omitempty"`
+		service      *Service            `yaml:"-"`
+		Envfiles     []string            `yaml:"envs,omitempty"`
+		Dir          string              `yaml:"dir,omitempty"`
+		RawEnv       map[string]EnvValue `yaml:"env,omitempty"`
+		Env          map[string]string   `yaml:"-"`
+		Args         []*Arg              `yaml:"args,omitempty"`
+		Flags        []*Arg              `yaml:"flags,omitempty"`
+		Confirm      string              `yaml:"confirm,omitempty"`
+		Prompts      []*Prompt           `yaml:"prompt,omitempty"`
+		Before       []*Command          `yaml:"before,omitempty"`
+		Cmd          []*Command          `yaml:"cmds,omitempty"`
+		After        []*Command          `yaml:"after,omitempty"`
+		Outputs      []string            `yaml:"outputs,omitempty"`
+		Matrix       map[string][]string `yaml:"matrix,omitempty"`
+		MatrixValues map[string]string   `yaml:"-"`
+		Needs        []string            `yaml:"needs,omitempty"`
+		Ready        string              `yaml:"ready,omitempty"`
+		Schedule     *Schedule           `yaml:"schedule,omitempty"`
+		Environment  string              `yaml:"environment,omitempty"`
+		Templates    []*Template         `yaml:"templates,omitempty"`
+		Overlays     map[string]any      `yaml:",inline"`
+		overlays     overlays
+		environment  string
+		layers       envStack
+		envComputed  bool
 	}
 )
 
 var templateProcfile = &Procfile{
 	Version: "1",
-	Env:     map[string]string{"DEBUG": "1"},
+	Env:     map[string]EnvValue{"DEBUG": {Value: "1"}},
 	Services: map[string]*Service{
-		"server": {Dir: "server", Cmd: []string{`echo "start server"`}, Env: map[string]string{"PORT": "8080"}},
-		"client": {Dir: "client", Cmd: []string{`npm init`}, Nixpkgs: []string{"nodejs-18_x"}},
+		"server": {Dir: "server", Cmd: []*Command{{Run: `echo "start server"`}}, RawEnv: map[string]EnvValue{"PORT": {Value: "8080"}}},
+		"client": {Dir: "client", Cmd: []*Command{{Run: `npm init`}}, Nixpkgs: []string{"nodejs-18_x"}},
 	},
 	Tasks: map[string]*Service{
-		"test": {Service: "client", Cmd: []string{`npm test`}},
+		"test": {Service: "client", Cmd: []*Command{{Run: `npm test`}}},
 	},
 }
 
@@ -64,22 +100,45 @@ func Create() error {
 	return templateProcfile.Write(filepath.Join(pwd, "grind.yml"))
 }
 
-// Parse will read a procfile and format it validly
+// Parse will read a procfile and format it validly, using the environment
+// selected by GRIND_ENV.
 func Parse(filename string) (*Procfile, error) {
+	return ParseEnv(filename, os.Getenv(EnvironmentVar))
+}
+
+// ParseEnv will read a procfile with an environment selected, which layers the
+// envs.<name> and env.<name> overlays and .env.<name> files on top of the env.
+func ParseEnv(filename, environment string) (*Procfile, error) {
 	fullPath, err := filepath.Abs(filename)
 	if err != nil {
 		return nil, err
 	}
 
 	procfile := &Procfile{
-		Dir:      filepath.Dir(fullPath),
-		Filepath: fullPath,
+		Dir:         filepath.Dir(fullPath),
+		Filepath:    fullPath,
+		Environment: environment,
 	}
 
 	if err := procfile.setup(); err != nil {
 		return nil, err
 	}
 
+	for name, svc := range procfile.Services {
+		if err := svc.prepare(name, procfile); err != nil {
+			return nil, err
+		}
+	}
+	for name, task := range procfile.Tasks {
+		task.IsTask = true
+		if err := task.prepare(name, procfile); err != nil {
+			return nil, err
+		}
+	}
+	if err := procfile.checkEnvironment(); err != nil {
+		return nil, err
+	}
+
 	for name, svc := range procfile.Services {
 		if err := svc.setup(name, procfile); err != nil {
 			return nil, err
@@ -89,12 +148,38 @@ func Parse(filename string) (*Procfile, error) {
 	for name, task := range procfile.Tasks {
 		if err := task.setup(name, procfile); err != nil {
 			return nil, err
+		} else if err := task.setupArgs(); err != nil {
+			return nil, err
+		} else if err := task.checkNeeds(); err != nil {
+			return nil, err
+		} else if err := task.checkSchedule(); err != nil {
+			return nil, err
 		}
-		task.IsTask = true
 	}
 	return procfile, nil
 }
 
+// Find looks up a service or task by name, services take precedence
+func (procfile *Procfile) Find(name string) (*Service, error) {
+	if svc := procfile.Services[name]; svc != nil {
+		return svc, nil
+	} else if task := procfile.Tasks[name]; task != nil {
+		return task, nil
+	}
+	return nil, fmt.Errorf("unknown service or task %v", name)
+}
+
+// Procfile is the grind.yml that the service or task was defined in
+func (svc *Service) Procfile() *Procfile {
+	return svc.procfile
+}
+
+// Inherits is the service that a task runs in the context of, or nil if it
+// does not inherit one.
+func (svc *Service) Inherits() *Service {
+	return svc.service
+}
+
 func (procfile *Procfile) Write(path string) error {
 	if file, err := os.Create(path); err != nil {
 		return err
@@ -107,68 +192,69 @@ func (procfile *Procfile) Write(path string) error {
 }
 
 func (procfile *Procfile) setup() error {
-	if byteData, err := os.ReadFile(procfile.Filepath); err != nil {
+	byteData, err := os.ReadFile(procfile.Filepath)
+	if err != nil {
 		return err
 	} else if err := yaml.UnmarshalStrict(byteData, &procfile); err != nil {
 		return err
 	} else if procfile.Version != "1" {
 		return fmt.Errorf("unknown procfile version %v requested", procfile.Version)
 	}
-	if procfile.Env == nil {
-		procfile.Env = map[string]string{}
-	}
-	return parseEnvFiles(procfile.Env, nil, procfile.Envfiles...)
+	return procfile.setupEnv(byteData)
 }
 
-// Environ will generate an array of the variables for a single service, inheriting
-// from the procfile and flag args. If the service is a task and inherits a service,
-// then it will inherit from that service, then procfile, and flag args
-func (svc *Service) Environ() []string {
-	env := []string{}
-	if svc.IsTask {
-		env = append(env, svc.service.Environ()...)
-	}
-	if !svc.Isolated {
-		env = append(env, os.Environ()...)
-	}
-	for key, val := range svc.Env {
-		env = append(env, key+"="+val)
-	}
-	return env
+// prepare sets up everything that another service or task could need from
+// this one when inheriting it, before any of them are setup.
+func (svc *Service) prepare(name string, procfile *Procfile) error {
+	svc.Name = name
+	svc.Dir = filepath.Join(procfile.Dir, svc.Dir)
+	svc.procfile = procfile
+	overlays, err := parseOverlays(svc.Overlays, name)
+	svc.overlays = overlays
+	return err
 }
 
-// EnvKeys will collect all the env keys that are set for the service. This is
-// used for isolated shells to tell nix-shell to keep those values
-func (svc *Service) EnvKeys() []string {
-	keys := []string{}
-	if svc.IsTask {
-		keys = append(keys, svc.service.EnvKeys()...)
+func (svc *Service) setup(name string, procfile *Procfile) error {
+	svc.Nixpkgs = appendUnique(svc.Nixpkgs, procfile.Nixpkgs...)
+	if err := svc.inherit(); err != nil {
+		return err
+	} else if err := svc.setupEnv(); err != nil {
+		return fmt.Errorf("%v: %v", name, err)
 	}
-	for key := range svc.Env {
-		keys = append(keys, key)
+	for _, tmpl := range svc.Templates {
+		if err := tmpl.setup(svc); err != nil {
+			return err
+		}
 	}
-	return keys
+	return nil
 }
 
-func (svc *Service) setup(name string, procfile *Procfile) error {
-	svc.Name = name
-	svc.Dir = filepath.Join(procfile.Dir, svc.Dir)
-	svc.Nixpkgs = append(svc.Nixpkgs, procfile.Nixpkgs...)
-	svc.procfile = procfile
-	if svc.Env == nil {
-		svc.Env = map[string]string{}
+func (svc *Service) checkSchedule() error {
+	if svc.Schedule == nil {
+		return nil
+	} else if svc.Confirm != "" {
+		return fmt.Errorf("scheduled task %v cannot ask for confirmation", svc.Name)
 	}
-	if err := parseEnvFiles(svc.Env, procfile.Env, svc.Envfiles...); err != nil {
-		return err
+	defaults := map[string]bool{}
+	for _, arg := range append(append([]*Arg{}, svc.Args...), svc.Flags...) {
+		if arg.Required {
+			return fmt.Errorf("scheduled task %v cannot have required arg %v", svc.Name, arg.Name)
+		}
+		defaults[arg.Name] = arg.Default != "" || arg.Type == "bool"
 	}
-	if err := svc.inherit(); err != nil {
-		return err
+	for _, prompt := range svc.Prompts {
+		if prompt.Default == "" && !defaults[prompt.Name] {
+			return fmt.Errorf("scheduled task %v cannot prompt for %v without a default", svc.Name, prompt.Name)
+		}
 	}
-	if svc.IsTask {
-		svc.Env["SVC"] = svc.Service
-		svc.Env["TASK"] = svc.Name
-	} else {
-		svc.Env["SVC"] = svc.Name
+	return nil
+}
+
+func (svc *Service) checkNeeds() error {
+	for _, name := range svc.Needs {
+		if svc.procfile.Services[name] == nil {
+			return fmt.Errorf("%v needs %v which is not a service", svc.Name, name)
+		}
 	}
 	return nil
 }
@@ -180,27 +266,19 @@ func (svc *Service) inherit() error {
 		return fmt.Errorf("%v tried to inherit %v which does not exist", svc.Name, svc.Service)
 	}
 	svc.service = svc.procfile.Services[svc.Service]
-	svc.Nixpkgs = append(svc.Nixpkgs, svc.service.Nixpkgs...)
+	svc.Nixpkgs = appendUnique(svc.Nixpkgs, svc.service.Nixpkgs...)
 	svc.Dir = svc.service.Dir
-	for key, val := range svc.service.Env {
-		svc.Env[key] = val
-	}
 	return nil
 }
 
-func parseEnvFiles(env, ext map[string]string, files ...string) error {
-	for key, val := range env {
-		env[key] = os.Expand(val, func(v string) string {
-			if val, ok := env[v]; ok {
-				return val
-			} else if val, ok := ext[v]; ok {
-				return val
-			}
-			return os.Getenv(v)
-		})
-	}
-	for key, val := range ext {
-		env[key] = val
-	}
-	return envfile.Parse(env, files...)
+// appendUnique appends the values that are not already in the list, like the
+// global nixpkgs that a task gets both directly and from the service it
+// inherits.
+func appendUnique(list []string, vals ...string) []string {
+	for _, val := range vals {
+		if !slices.Contains(list, val) {
+			list = append(list, val)
+		}
+	}
+	return list
 }
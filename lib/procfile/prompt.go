@@ -0,0 +1,31 @@
+package procfile
+
+import "fmt"
+
+// Prompt asks the user for a value before a task is run. The value is set in
+// the same way as a declared arg, and the prompt is skipped if an arg or flag
+// with the same name was passed.
+type Prompt struct {
+	Name    string   `yaml:"name"`
+	Message string   `yaml:"message,omitempty"`
+	Type    string   `yaml:"type,omitempty"`
+	Options []string `yaml:"options,omitempty"`
+	Default string   `yaml:"default,omitempty"`
+}
+
+func (prompt *Prompt) setup(task string) error {
+	if prompt.Type == "" {
+		prompt.Type = "text"
+	}
+	if prompt.Message == "" {
+		prompt.Message = prompt.Name
+	}
+	if !argNamePattern.MatchString(prompt.Name) {
+		return fmt.Errorf("task %v has an invalid prompt name %q", task, prompt.Name)
+	} else if prompt.Type != "text" && prompt.Type != "select" && prompt.Type != "password" {
+		return fmt.Errorf("task %v prompt %v has unknown type %v, expected text, select, or password", task, prompt.Name, prompt.Type)
+	} else if prompt.Type == "select" && len(prompt.Options) == 0 {
+		return fmt.Errorf("task %v prompt %v is a select but has no options", task, prompt.Name)
+	}
+	return nil
+}
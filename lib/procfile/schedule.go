@@ -0,0 +1,72 @@
+package procfile
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Schedule is when a task should be run while services are running. It can be
+// written as a cron expression, or as an object with an every interval.
+type Schedule struct {
+	Cron     string        `yaml:"cron,omitempty"`
+	Every    time.Duration `yaml:"every,omitempty"`
+	schedule cron.Schedule
+}
+
+// UnmarshalYAML allows a schedule to be defined as a cron expression string or
+// an object with cron or every set.
+func (sched *Schedule) UnmarshalYAML(unmarshal func(any) error) error {
+	var expr string
+	if err := unmarshal(&expr); err == nil {
+		*sched = Schedule{Cron: expr}
+	} else {
+		type rawSchedule Schedule
+		var raw rawSchedule
+		if err := unmarshal(&raw); err != nil {
+			return err
+		}
+		*sched = Schedule(raw)
+	}
+	return sched.setup()
+}
+
+// MarshalYAML will output schedules with only a cron expression as a string
+func (sched Schedule) MarshalYAML() (any, error) {
+	if sched.Every == 0 {
+		return sched.Cron, nil
+	}
+	return map[string]string{"every": sched.Every.String()}, nil
+}
+
+func (sched *Schedule) setup() error {
+	if (sched.Cron == "") == (sched.Every == 0) {
+		return fmt.Errorf("schedule requires either a cron expression or every")
+	} else if sched.Every < 0 {
+		return fmt.Errorf("schedule every cannot be negative")
+	} else if sched.Every > 0 {
+		return nil
+	}
+	schedule, err := cron.ParseStandard(sched.Cron)
+	if err != nil {
+		return fmt.Errorf("invalid schedule %q: %v", sched.Cron, err)
+	}
+	sched.schedule = schedule
+	return nil
+}
+
+// Next returns the next time the task should run after a time
+func (sched *Schedule) Next(after time.Time) time.Time {
+	if sched.Every > 0 {
+		return after.Add(sched.Every)
+	}
+	return sched.schedule.Next(after)
+}
+
+func (sched Schedule) String() string {
+	if sched.Every > 0 {
+		return "every " + sched.Every.String()
+	}
+	return sched.Cron
+}
@@ -0,0 +1,39 @@
+package procfile
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSchedule(t *testing.T) {
+	now := time.Date(2023, 5, 10, 12, 30, 0, 0, time.Local)
+
+	var sched Schedule
+	assert.Nil(t, yaml.UnmarshalStrict([]byte(`"0 3 * * *"`), &sched))
+	assert.Equal(t, time.Date(2023, 5, 11, 3, 0, 0, 0, time.Local), sched.Next(now))
+	assert.Equal(t, "0 3 * * *", sched.String())
+
+	assert.Nil(t, yaml.UnmarshalStrict([]byte(`every: 10m`), &sched))
+	assert.Equal(t, now.Add(10*time.Minute), sched.Next(now))
+	assert.Equal(t, "every 10m0s", sched.String())
+	out, err := yaml.Marshal(sched)
+	assert.Nil(t, err)
+	assert.Equal(t, "every: 10m0s\n", string(out))
+
+	assert.NotNil(t, yaml.UnmarshalStrict([]byte(`"every day"`), &sched))
+	assert.NotNil(t, yaml.UnmarshalStrict([]byte(`{}`), &sched))
+	assert.NotNil(t, yaml.UnmarshalStrict([]byte(`{every: 1m, cron: "@daily"}`), &sched))
+}
+
+func TestCheckSchedule(t *testing.T) {
+	sched := &Schedule{Every: time.Minute}
+	assert.Nil(t, (&Service{Name: "t", Schedule: sched, Args: []*Arg{{Name: "pkg"}}}).checkSchedule())
+	assert.NotNil(t, (&Service{Name: "t", Schedule: sched, Confirm: "sure?"}).checkSchedule())
+	assert.NotNil(t, (&Service{Name: "t", Schedule: sched, Flags: []*Arg{{Name: "pkg", Required: true}}}).checkSchedule())
+	assert.NotNil(t, (&Service{Name: "t", Schedule: sched, Prompts: []*Prompt{{Name: "target"}}}).checkSchedule())
+	assert.Nil(t, (&Service{Name: "t", Schedule: sched, Prompts: []*Prompt{{Name: "target", Default: "staging"}}}).checkSchedule())
+	assert.Nil(t, (&Service{Name: "t", Schedule: sched, Flags: []*Arg{{Name: "target", Default: "staging"}}, Prompts: []*Prompt{{Name: "target"}}}).checkSchedule())
+}
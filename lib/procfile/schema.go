@@ -0,0 +1,180 @@
+package procfile
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/tanema/grind/lib/envfile"
+)
+
+// EnvSpec declares a variable in the env_schema of the grind.yml. The resolved
+// env of every service and task that it applies to is checked against it
+// before anything is started.
+type EnvSpec struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"desc,omitempty"`
+	Type        string   `yaml:"type,omitempty"`
+	Required    bool     `yaml:"required,omitempty"`
+	Pattern     string   `yaml:"pattern,omitempty"`
+	Default     string   `yaml:"default,omitempty"`
+	Secret      bool     `yaml:"secret,omitempty"`
+	Services    []string `yaml:"services,omitempty"`
+	pattern     *regexp.Regexp
+}
+
+// EnvViolation is a variable in the env of a service that does not match the
+// env_schema.
+type EnvViolation struct {
+	Name        string
+	Problem     string
+	Description string
+}
+
+func (spec *EnvSpec) setup(procfile *Procfile) error {
+	if spec.Type == "" {
+		spec.Type = "string"
+	}
+	if !envNamePattern.MatchString(spec.Name) {
+		return fmt.Errorf("env_schema has an invalid variable name %q", spec.Name)
+	} else if !slices.Contains([]string{"string", "int", "bool", "url", "port"}, spec.Type) {
+		return fmt.Errorf("env_schema %v has unknown type %v, expected string, int, bool, url, or port", spec.Name, spec.Type)
+	} else if spec.Required && spec.Default != "" {
+		return fmt.Errorf("env_schema %v cannot be required and have a default", spec.Name)
+	}
+	for _, name := range spec.Services {
+		if procfile.Services[name] == nil && procfile.Tasks[name] == nil {
+			return fmt.Errorf("env_schema %v applies to %v which is not a service or task", spec.Name, name)
+		}
+	}
+	if spec.Pattern != "" {
+		pattern, err := regexp.Compile(spec.Pattern)
+		if err != nil {
+			return fmt.Errorf("env_schema %v has an invalid pattern: %v", spec.Name, err)
+		}
+		spec.pattern = pattern
+	}
+	if spec.Default != "" {
+		if problem := spec.check(spec.Default); problem != "" {
+			return fmt.Errorf("env_schema %v has an invalid default: %v", spec.Name, problem)
+		}
+	}
+	return nil
+}
+
+// appliesTo checks if the spec should be validated for a service or task. A
+// task is also covered by the specs of the service that it inherits.
+func (spec *EnvSpec) appliesTo(svc *Service) bool {
+	if len(spec.Services) == 0 {
+		return true
+	}
+	name := svc.Name
+	if svc.MatrixValues != nil {
+		name, _, _ = strings.Cut(name, "[")
+	}
+	return slices.Contains(spec.Services, name) || (svc.Service != "" && slices.Contains(spec.Services, svc.Service))
+}
+
+// check returns what is wrong with a value that is set, or an empty string if
+// it is valid.
+func (spec *EnvSpec) check(val string) string {
+	switch spec.Type {
+	case "int":
+		if _, err := strconv.Atoi(val); err != nil {
+			return fmt.Sprintf("%q is not an int", val)
+		}
+	case "bool":
+		if _, err := strconv.ParseBool(val); err != nil {
+			return fmt.Sprintf("%q is not a bool", val)
+		}
+	case "port":
+		if port, err := strconv.Atoi(val); err != nil || port < 1 || port > 65535 {
+			return fmt.Sprintf("%q is not a port between 1 and 65535", val)
+		}
+	case "url":
+		if u, err := url.Parse(val); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Sprintf("%q is not a url with a scheme and host", val)
+		}
+	}
+	if spec.pattern != nil && !spec.pattern.MatchString(val) {
+		return fmt.Sprintf("%q does not match %v", val, spec.Pattern)
+	}
+	return ""
+}
+
+// CheckEnv validates the resolved env of the service against the env_schema,
+// returning every variable that does not match.
+func (svc *Service) CheckEnv() []EnvViolation {
+	env := svc.environ()
+	violations := []EnvViolation{}
+	for _, spec := range svc.procfile.EnvSchema {
+		if !spec.appliesTo(svc) {
+			continue
+		}
+		problem := ""
+		if val := env[spec.Name]; val == "" && spec.Required {
+			problem = "required but not set"
+		} else if val != "" {
+			problem = spec.check(val)
+		}
+		if problem != "" {
+			violations = append(violations, EnvViolation{Name: spec.Name, Problem: problem, Description: spec.Description})
+		}
+	}
+	return violations
+}
+
+// WriteEnvExample outputs an env file with every variable in the env_schema,
+// documented with its description and constraints, to be used as a template
+// like .env.example.
+func (procfile *Procfile) WriteEnvExample(w io.Writer) error {
+	for i, spec := range procfile.EnvSchema {
+		lines := []string{}
+		if i > 0 {
+			lines = append(lines, "")
+		}
+		if spec.Description != "" {
+			lines = append(lines, "# "+spec.Description)
+		}
+		info := []string{spec.Type}
+		if spec.Required {
+			info = append(info, "required")
+		}
+		if spec.Secret {
+			info = append(info, "secret")
+		}
+		if spec.Pattern != "" {
+			info = append(info, "pattern "+spec.Pattern)
+		}
+		if len(spec.Services) > 0 {
+			info = append(info, "used by "+strings.Join(spec.Services, ", "))
+		}
+		lines = append(lines, "# "+strings.Join(info, ", "))
+		if _, err := fmt.Fprintln(w, strings.Join(lines, "\n")); err != nil {
+			return err
+		} else if err := envfile.Write(w, envfile.FormatDotenv, map[string]string{spec.Name: spec.Default}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// schemaLayer sets the defaults from the env_schema for any variables that are
+// not set on the host.
+func (procfile *Procfile) schemaLayer() envLayer {
+	layer := envLayer{}
+	for i, spec := range procfile.EnvSchema {
+		if _, ok := os.LookupEnv(spec.Name); ok || spec.Default == "" {
+			continue
+		}
+		source := procfile.source(fmt.Sprintf("env_schema.%v.default", i))
+		layer[spec.Name] = EnvVar{Key: spec.Name, Value: spec.Default, Source: source}
+	}
+	return layer
+}
@@ -0,0 +1,103 @@
+package procfile
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvSpecSetup(t *testing.T) {
+	pfile := &Procfile{Services: map[string]*Service{"server": {}}}
+	testCases := []struct {
+		spec EnvSpec
+		err  string
+	}{
+		{spec: EnvSpec{Name: "PORT", Type: "port", Default: "8080", Services: []string{"server"}}},
+		{spec: EnvSpec{Name: "1PORT"}, err: `env_schema has an invalid variable name "1PORT"`},
+		{spec: EnvSpec{Name: "PORT", Type: "float"}, err: "env_schema PORT has unknown type float, expected string, int, bool, url, or port"},
+		{spec: EnvSpec{Name: "PORT", Required: true, Default: "80"}, err: "env_schema PORT cannot be required and have a default"},
+		{spec: EnvSpec{Name: "PORT", Services: []string{"db"}}, err: "env_schema PORT applies to db which is not a service or task"},
+		{spec: EnvSpec{Name: "PORT", Pattern: "("}, err: "env_schema PORT has an invalid pattern: error parsing regexp: missing closing ): `(`"},
+		{spec: EnvSpec{Name: "PORT", Type: "port", Default: "0"}, err: `env_schema PORT has an invalid default: "0" is not a port between 1 and 65535`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.err, func(t *testing.T) {
+			err := tc.spec.setup(pfile)
+			if tc.err == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestEnvSpecCheck(t *testing.T) {
+	testCases := []struct {
+		spec    EnvSpec
+		val     string
+		problem string
+	}{
+		{spec: EnvSpec{Type: "string"}, val: "anything"},
+		{spec: EnvSpec{Type: "int"}, val: "42"},
+		{spec: EnvSpec{Type: "int"}, val: "4.2", problem: `"4.2" is not an int`},
+		{spec: EnvSpec{Type: "bool"}, val: "true"},
+		{spec: EnvSpec{Type: "bool"}, val: "yes", problem: `"yes" is not a bool`},
+		{spec: EnvSpec{Type: "port"}, val: "5432"},
+		{spec: EnvSpec{Type: "port"}, val: "70000", problem: `"70000" is not a port between 1 and 65535`},
+		{spec: EnvSpec{Type: "url"}, val: "postgres://localhost:5432/db"},
+		{spec: EnvSpec{Type: "url"}, val: "localhost", problem: `"localhost" is not a url with a scheme and host`},
+		{spec: EnvSpec{Type: "string", Pattern: "^(debug|info)$"}, val: "debug"},
+		{spec: EnvSpec{Type: "string", Pattern: "^(debug|info)$"}, val: "trace", problem: `"trace" does not match ^(debug|info)$`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.spec.Type+" "+tc.val, func(t *testing.T) {
+			spec := tc.spec
+			spec.Name = "VAR"
+			require.Nil(t, spec.setup(&Procfile{}))
+			assert.Equal(t, tc.problem, spec.check(tc.val))
+		})
+	}
+}
+
+func TestCheckEnv(t *testing.T) {
+	server := &Service{Name: "server", Isolated: true, Env: map[string]string{"GRIND_TEST_PORT": "http"}}
+	pfile := &Procfile{
+		Services: map[string]*Service{"server": server},
+		EnvSchema: []*EnvSpec{
+			{Name: "GRIND_TEST_URL", Type: "url", Required: true, Description: "the db", Services: []string{"server"}},
+			{Name: "GRIND_TEST_PORT", Type: "port"},
+		},
+	}
+	for _, spec := range pfile.EnvSchema {
+		require.Nil(t, spec.setup(pfile))
+	}
+	server.procfile = pfile
+	assert.Equal(t, []EnvViolation{
+		{Name: "GRIND_TEST_URL", Problem: "required but not set", Description: "the db"},
+		{Name: "GRIND_TEST_PORT", Problem: `"http" is not a port between 1 and 65535`},
+	}, server.CheckEnv())
+
+	task := &Service{Name: "migrate", Service: "server", procfile: pfile, Isolated: true, Env: map[string]string{"GRIND_TEST_URL": "postgres://db"}}
+	assert.Empty(t, task.CheckEnv())
+	worker := &Service{Name: "worker", procfile: pfile, Isolated: true}
+	assert.Empty(t, worker.CheckEnv())
+}
+
+func TestWriteEnvExample(t *testing.T) {
+	pfile := &Procfile{EnvSchema: []*EnvSpec{
+		{Name: "DATABASE_URL", Type: "url", Required: true, Description: "Connection string for postgres"},
+		{Name: "LOG_LEVEL", Type: "string", Pattern: "^(debug|info)$", Default: "info"},
+	}}
+	var buf bytes.Buffer
+	require.Nil(t, pfile.WriteEnvExample(&buf))
+	assert.Equal(t, `# Connection string for postgres
+# url, required
+DATABASE_URL=
+
+# string, pattern ^(debug|info)$
+LOG_LEVEL=info
+`, buf.String())
+}
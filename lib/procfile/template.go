@@ -0,0 +1,141 @@
+package procfile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/tanema/grind/lib/term"
+)
+
+type (
+	// Template is a config file that is rendered with the env of the service
+	// before the service starts, like the config of mysql or nginx that needs
+	// the resolved ports and paths.
+	Template struct {
+		Src  string `yaml:"src"`
+		Dest string `yaml:"dest"`
+	}
+	// TemplateData is the data that templates are rendered with
+	TemplateData struct {
+		// Dir is the dir of the grind.yml
+		Dir string
+		// Name, ServiceDir, Env, and Port are of the service being rendered. Env
+		// includes the host environment unless the service is isolated, and Port
+		// is the value of PORT that the service sets.
+		Name       string
+		ServiceDir string
+		Env        map[string]string
+		Port       string
+		// Services has every service, including the one being rendered
+		Services map[string]TemplateService
+	}
+	// TemplateService is a service as seen by a template
+	TemplateService struct {
+		Name string
+		Dir  string
+		Env  map[string]string
+		Port string
+	}
+)
+
+func (tmpl *Template) setup(svc *Service) error {
+	if tmpl.Src == "" || tmpl.Dest == "" {
+		return fmt.Errorf("templates of %v need both src and dest", svc.Name)
+	}
+	tmpl.Src = svc.path(tmpl.Src)
+	tmpl.Dest = svc.path(tmpl.Dest)
+	if tmpl.Src == tmpl.Dest {
+		return fmt.Errorf("template %v of %v cannot render to itself", tmpl.Src, svc.Name)
+	}
+	return nil
+}
+
+func (svc *Service) path(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(svc.Dir, path)
+}
+
+// TemplateFiles lists the src of every template of the service
+func (svc *Service) TemplateFiles() []string {
+	files := []string{}
+	for _, tmpl := range svc.Templates {
+		files = append(files, tmpl.Src)
+	}
+	return files
+}
+
+// RenderTemplates renders every template of the service from its src to its
+// dest. A template fails if it references a key that is not set in a map, like
+// an env var that is not set, while env "NAME" looks up one that may not be.
+// The env of every service is computed first with the executor since templates
+// can reference any of them.
+func (svc *Service) RenderTemplates(ctx context.Context, executor Executor) error {
+	if len(svc.Templates) == 0 {
+		return nil
+	}
+	data, err := svc.templateData(ctx, executor)
+	if err != nil {
+		return err
+	}
+	for _, tmpl := range svc.Templates {
+		if err := tmpl.render(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tmpl *Template) render(data TemplateData) error {
+	src, err := os.ReadFile(tmpl.Src)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(tmpl.Src)
+	if err != nil {
+		return err
+	}
+	parsed, err := template.New(filepath.Base(tmpl.Src)).
+		Funcs(term.TextFuncs()).
+		Funcs(template.FuncMap{"env": func(name string) string { return data.Env[name] }}).
+		Option("missingkey=error").
+		Parse(string(src))
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := parsed.Execute(&out, data); err != nil {
+		return err
+	} else if err := os.MkdirAll(filepath.Dir(tmpl.Dest), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(tmpl.Dest, out.Bytes(), info.Mode().Perm())
+}
+
+func (svc *Service) templateData(ctx context.Context, executor Executor) (TemplateData, error) {
+	if err := svc.ComputeEnv(ctx, executor); err != nil {
+		return TemplateData{}, err
+	}
+	services := map[string]TemplateService{}
+	for name, other := range svc.procfile.Services {
+		if err := other.ComputeEnv(ctx, executor); err != nil {
+			return TemplateData{}, err
+		}
+		env := other.environ()
+		services[name] = TemplateService{Name: name, Dir: other.Dir, Env: env, Port: other.Env["PORT"]}
+	}
+	env := svc.environ()
+	return TemplateData{
+		Dir:        svc.procfile.Dir,
+		Name:       svc.Name,
+		ServiceDir: svc.Dir,
+		Env:        env,
+		Port:       svc.Env["PORT"],
+		Services:   services,
+	}, nil
+}
@@ -0,0 +1,75 @@
+package procfile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderTemplates(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		require.Nil(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644))
+	}
+	write("nginx.conf.tmpl", `server {
+  listen {{.Port}};
+  root {{.Dir}}/public;
+  location /api { proxy_pass http://localhost:{{(index .Services "api").Port}}; }
+  # {{.Env.MODE | upper | default "none"}} {{.Name}}
+}
+`)
+	write("grind.yml", `version: "1"
+services:
+  api:
+    cmds: [echo]
+    env:
+      PORT: "9000"
+  nginx:
+    cmds: [echo]
+    env:
+      PORT: "8080"
+      MODE: dev
+    templates:
+      - src: nginx.conf.tmpl
+        dest: tmp/nginx.conf
+`)
+	procfile, err := Parse(filepath.Join(dir, "grind.yml"))
+	require.Nil(t, err)
+	nginx := procfile.Services["nginx"]
+	assert.Equal(t, []string{filepath.Join(dir, "nginx.conf.tmpl")}, nginx.TemplateFiles())
+	require.Nil(t, nginx.RenderTemplates(context.Background(), nil))
+	rendered, err := os.ReadFile(filepath.Join(dir, "tmp", "nginx.conf"))
+	require.Nil(t, err)
+	assert.Equal(t, `server {
+  listen 8080;
+  root `+dir+`/public;
+  location /api { proxy_pass http://localhost:9000; }
+  # DEV nginx
+}
+`, string(rendered))
+
+	write("nginx.conf.tmpl", "{{.Env.GRIND_TEST_MISSING}}")
+	assert.ErrorContains(t, nginx.RenderTemplates(context.Background(), nil), `map has no entry for key "GRIND_TEST_MISSING"`)
+	write("nginx.conf.tmpl", `{{env "GRIND_TEST_MISSING" | default "info"}} {{env "MODE" | default "prod"}}`)
+	require.Nil(t, nginx.RenderTemplates(context.Background(), nil))
+	rendered, err = os.ReadFile(filepath.Join(dir, "tmp", "nginx.conf"))
+	require.Nil(t, err)
+	assert.Equal(t, "info dev", string(rendered))
+	write("nginx.conf.tmpl", "{{.Env.PORT | bold}}")
+	assert.ErrorContains(t, nginx.RenderTemplates(context.Background(), nil), `function "bold" not defined`)
+}
+
+func TestTemplateSetup(t *testing.T) {
+	svc := &Service{Name: "nginx", Dir: "/app"}
+	tmpl := &Template{Src: "nginx.conf"}
+	assert.EqualError(t, tmpl.setup(svc), "templates of nginx need both src and dest")
+	tmpl = &Template{Src: "nginx.conf", Dest: "/app/nginx.conf"}
+	assert.EqualError(t, tmpl.setup(svc), "template /app/nginx.conf of nginx cannot render to itself")
+	tmpl = &Template{Src: "nginx.conf.tmpl", Dest: "/etc/nginx.conf"}
+	require.Nil(t, tmpl.setup(svc))
+	assert.Equal(t, &Template{Src: "/app/nginx.conf.tmpl", Dest: "/etc/nginx.conf"}, tmpl)
+}
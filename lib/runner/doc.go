@@ -0,0 +1,25 @@
+// Package runner runs the services and tasks of a project loaded by the
+// procfile package. A Runner is created with New and a Config, which sets where
+// output is written, a channel to receive every line and lifecycle event as an
+// Entry, and the Executor that runs commands. Commands run in a nix-shell by
+// default, use a HostExecutor to run them with the shell on the host instead.
+//
+//	events := make(chan runner.Entry)
+//	go func() {
+//		for entry := range events {
+//			fmt.Println(entry.Name, entry.Event, entry.Line)
+//		}
+//	}()
+//	r := runner.New(runner.Config{
+//		Procfile: project,
+//		Stdout:   io.Discard,
+//		Stderr:   io.Discard,
+//		Events:   events,
+//		Executor: runner.HostExecutor{},
+//	})
+//	err := r.RunTask("test", true, nil, nil)
+//
+// RunServices starts services until they exit or the process is interrupted,
+// RunTask runs a single task with its args and flags, and Report returns the
+// results of every command that was run.
+package runner
@@ -0,0 +1,58 @@
+package runner_test
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/tanema/grind/lib/procfile"
+	"github.com/tanema/grind/lib/runner"
+)
+
+func Example() {
+	dir, err := os.MkdirTemp("", "grind-example")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	config := `version: "1"
+env:
+  GREETING: hello
+tasks:
+  greet:
+    cmds:
+      - echo "$GREETING $1"
+`
+	if err := os.WriteFile(filepath.Join(dir, "grind.yml"), []byte(config), 0o644); err != nil {
+		panic(err)
+	}
+	project, err := procfile.Parse(filepath.Join(dir, "grind.yml"))
+	if err != nil {
+		panic(err)
+	}
+
+	events := make(chan runner.Entry, 10)
+	r := runner.New(runner.Config{
+		Procfile: project,
+		Stdout:   io.Discard,
+		Stderr:   io.Discard,
+		Events:   events,
+		Executor: runner.HostExecutor{},
+	})
+	err = r.RunTask("greet", true, []string{"world"}, nil)
+	close(events)
+	for entry := range events {
+		switch {
+		case entry.Stream != "":
+			fmt.Println(entry.Name, entry.Stream, entry.Line)
+		case entry.Event == "exit":
+			fmt.Println(entry.Name, entry.Event, *entry.Code)
+		}
+	}
+	fmt.Println(err)
+	// Output:
+	// greet stdout hello world
+	// greet exit 0
+	// <nil>
+}
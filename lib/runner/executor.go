@@ -0,0 +1,129 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/tanema/grind/lib/procfile"
+)
+
+type (
+	// Executor builds the process that runs a shell command in the environment
+	// of a service or task. An empty shell command starts an interactive shell.
+	// The runner sets the dir, env, stdio, and cancellation of the command that
+	// is returned.
+	Executor interface {
+		Command(ctx context.Context, svc *procfile.Service, shellCmd string, keep []string) *exec.Cmd
+	}
+	// NixExecutor runs commands in a nix-shell with the nixpkgs of the service.
+	// Isolated services run in a pure shell that only keeps the env vars in keep.
+	// This is the default executor.
+	NixExecutor struct{}
+	// HostExecutor runs commands directly on the host with a shell, ignoring the
+	// nixpkgs of the service. It is useful for tests, or on machines where every
+	// tool is already installed.
+	HostExecutor struct {
+		// Shell is the shell that runs commands with -c, it defaults to sh
+		Shell string
+	}
+	// readyExecutor is an Executor that writes a byte to fd 3 of the command once
+	// the environment is set up, right before the shell command runs, so that
+	// setting up the environment can be told apart from the command itself.
+	readyExecutor interface {
+		signalsReady()
+	}
+	// readyPipe is passed to a command as fd 3 for a readyExecutor to signal on
+	readyPipe struct {
+		reader *os.File
+		writer *os.File
+		ready  chan bool
+	}
+)
+
+// readyPipeTimeout is how long to wait for the ready signal to be read once the
+// command has exited.
+const readyPipeTimeout = time.Second
+
+// readyScript signals on fd 3 when the shell starts, then runs the command that
+// is passed to it quoted, so that a syntax error in the command still comes
+// after the signal. Errors are hidden for when nothing was passed as fd 3.
+const readyScript = "{ printf . >&3; exec 3>&-; } 2>/dev/null; eval "
+
+// Command builds a nix-shell command
+func (NixExecutor) Command(ctx context.Context, svc *procfile.Service, shellCmd string, keep []string) *exec.Cmd {
+	args := []string{`<nixpkgs>`}
+	if svc.Isolated {
+		args = append(args, "--pure")
+		for _, key := range keep {
+			args = append(args, "--keep", key)
+		}
+	}
+	args = append(args, append([]string{"--packages"}, svc.Nixpkgs...)...)
+	if shellCmd != "" {
+		args = append(args, "--command", readyScript+shellQuote(shellCmd))
+	}
+	return exec.CommandContext(ctx, "nix-shell", args...)
+}
+
+// signalsReady implements readyExecutor, nix-shell always runs the command with
+// bash so the ready script can be used.
+func (NixExecutor) signalsReady() {}
+
+// Command builds a command that runs with the shell on the host
+func (host HostExecutor) Command(ctx context.Context, svc *procfile.Service, shellCmd string, keep []string) *exec.Cmd {
+	shell := host.Shell
+	if shell == "" {
+		shell = "sh"
+	}
+	if shellCmd == "" {
+		return exec.CommandContext(ctx, shell)
+	}
+	return exec.CommandContext(ctx, shell, "-c", shellCmd)
+}
+
+// watchReady passes a pipe to the command as fd 3 if the executor signals on it
+// once the environment is ready, returning nil if it does not.
+func (proc *Process) watchReady(cmdProc *exec.Cmd) (*readyPipe, error) {
+	if _, ok := proc.runner.executor.(readyExecutor); !ok {
+		return nil, nil
+	}
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmdProc.ExtraFiles = []*os.File{writer}
+	return &readyPipe{reader: reader, writer: writer, ready: make(chan bool, 1)}, nil
+}
+
+// start should be called once the command has been started, whether it failed
+// or not. It closes our end of the pipe and waits for the signal, calling
+// onReady if it comes.
+func (pipe *readyPipe) start(onReady func()) {
+	pipe.writer.Close()
+	go func() {
+		defer pipe.reader.Close()
+		_, err := pipe.reader.Read(make([]byte, 1))
+		if err == nil && onReady != nil {
+			onReady()
+		}
+		pipe.ready <- err == nil
+	}()
+}
+
+// wait reports if the executor signalled that the environment was ready, it
+// should only be called once the command has exited.
+func (pipe *readyPipe) wait() bool {
+	select {
+	case ok := <-pipe.ready:
+		return ok
+	case <-time.After(readyPipeTimeout):
+		return false
+	}
+}
+
+func shellQuote(str string) string {
+	return "'" + strings.ReplaceAll(str, "'", `'\''`) + "'"
+}
@@ -1,21 +1,300 @@
 package runner
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// LogFormat is the format that captured process output is written in
+type LogFormat string
+
+const (
+	// LogText is the default colored output, prefixed with the process name
+	LogText LogFormat = "text"
+	// LogJSON outputs every line and lifecycle event as a json object
+	LogJSON LogFormat = "json"
+	// LogFmt outputs every line and lifecycle event as logfmt key/value pairs
+	LogFmt LogFormat = "logfmt"
 )
 
-// Logger is a simple logger for prefixing outputs
+// Entry is a single structured log line or lifecycle event
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Name     string    `json:"name"`
+	Kind     string    `json:"kind"`
+	Stream   string    `json:"stream,omitempty"`
+	Event    string    `json:"event,omitempty"`
+	Step     string    `json:"step,omitempty"`
+	Cmd      string    `json:"cmd,omitempty"`
+	PID      int       `json:"pid,omitempty"`
+	Code     *int      `json:"code,omitempty"`
+	Duration string    `json:"duration,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Line     string    `json:"line,omitempty"`
+}
+
+// Logger buffers the stdout and stderr of a single process and hands it off to
+// the Mux one full line at a time.
 type Logger struct {
+	mux    *Mux
+	mut    sync.Mutex
 	prefix string
-	writer io.Writer
+	name   string
+	kind   string
+	stdout *stream
+	stderr *stream
+}
+
+type stream struct {
+	logger *Logger
+	name   string
+	buf    []byte
+	timer  *time.Timer
+}
+
+// String implements pflag.Value
+func (format LogFormat) String() string {
+	if format == "" {
+		return string(LogText)
+	}
+	return string(format)
+}
+
+// Set implements pflag.Value and validates the requested format
+func (format *LogFormat) Set(val string) error {
+	switch LogFormat(val) {
+	case LogText, LogJSON, LogFmt:
+		*format = LogFormat(val)
+		return nil
+	}
+	return fmt.Errorf("unknown log format %v, expected one of text, json, logfmt", val)
+}
+
+// Type implements pflag.Value
+func (format LogFormat) Type() string {
+	return "format"
+}
+
+func (format LogFormat) structured() bool {
+	return format == LogJSON || format == LogFmt
+}
+
+// Stdout returns the writer for the stdout stream of the process
+func (w *Logger) Stdout() io.Writer {
+	return w.stdout
+}
+
+// Stderr returns the writer for the stderr stream of the process
+func (w *Logger) Stderr() io.Writer {
+	return w.stderr
+}
+
+// Flush will write out any partial lines that are still buffered
+func (w *Logger) Flush() error {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return w.flush()
+}
+
+func (w *Logger) flush() error {
+	if err := w.stdout.flush(); err != nil {
+		return err
+	}
+	return w.stderr.flush()
+}
+
+func (w *Logger) start(cmd string) {
+	w.event(Entry{Event: "start", Cmd: cmd}, fmt.Sprintf("🚀 => %v", cmd))
+}
+
+func (w *Logger) ready(cmd string, pid int) {
+	w.event(Entry{Event: "ready", Cmd: cmd, PID: pid}, "")
+}
+
+func (w *Logger) skip(cmd, reason string) {
+	w.event(Entry{Event: "skip", Cmd: cmd, Reason: reason}, color.New(color.Faint).Sprintf("⏭  skipped %v, %v.", cmd, reason))
+}
+
+func (w *Logger) retry(cmd string, attempt, retries int, delay time.Duration) {
+	entry := Entry{Event: "retry", Cmd: cmd, Reason: fmt.Sprintf("attempt %v of %v", attempt, retries)}
+	w.event(entry, color.YellowString("🔁 retrying in %v, attempt %v of %v.", delay, attempt, retries))
+}
+
+func (w *Logger) ignored(cmd string, err error) {
+	w.event(Entry{Event: "ignore", Cmd: cmd, Error: err.Error()}, color.YellowString("⚠️  ignoring error: %v", err))
+}
+
+func (w *Logger) healthy() {
+	w.event(Entry{Event: "healthy"}, color.GreenString("🟢 ready."))
+}
+
+func (w *Logger) reuse(msg string) {
+	w.event(Entry{Event: "reuse"}, color.CyanString("♻️  %v.", msg))
+}
+
+func (w *Logger) render(files []string) {
+	w.event(Entry{Event: "render", Reason: strings.Join(files, ", ")}, color.New(color.Faint).Sprintf("📝 rendered %v.", strings.Join(files, ", ")))
+}
+
+func (w *Logger) restart(file string) {
+	w.event(Entry{Event: "restart", Reason: file + " changed"}, color.CyanString("🔄 %v changed, restarting...", file))
+}
+
+func (w *Logger) stopping(cmd string) {
+	w.event(Entry{Event: "stop", Cmd: cmd}, color.CyanString("stopping..."))
+}
+
+func (w *Logger) exit(cmd string, code int, duration time.Duration, err error, msg string) {
+	entry := Entry{Event: "exit", Cmd: cmd, Code: &code, Duration: duration.String()}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	w.event(entry, msg)
+}
+
+func (w *Logger) step(step string, duration time.Duration, err error) {
+	entry := Entry{Event: "step", Step: step, Duration: duration.String()}
+	msg := color.GreenString("⏱  %v finished in %v.", step, duration)
+	if err != nil {
+		entry.Error = err.Error()
+		msg = color.RedString("⏱  %v failed after %v.", step, duration)
+	}
+	w.event(entry, msg)
+}
+
+// event will write a lifecycle event, in text mode the msg is output instead
+func (w *Logger) event(entry Entry, msg string) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	w.flush()
+	w.write(entry, msg)
+}
+
+func (w *Logger) log(entry Entry) error {
+	return w.write(entry, "")
+}
+
+func (w *Logger) write(entry Entry, msg string) error {
+	entry.Name = w.name
+	entry.Kind = w.kind
+	return w.mux.write(w.prefix, entry, msg)
+}
+
+func (s *stream) Write(b []byte) (int, error) {
+	s.logger.mut.Lock()
+	defer s.logger.mut.Unlock()
+	// keep the ordering between stdout and stderr by flushing what the other
+	// stream has buffered before accepting this write.
+	other := s.logger.stdout
+	if other == s {
+		other = s.logger.stderr
+	}
+	if err := other.flush(); err != nil {
+		return 0, err
+	}
+	s.buf = append(s.buf, b...)
+	for {
+		i := bytes.IndexByte(s.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(bytes.TrimSuffix(s.buf[:i], []byte("\r")))
+		s.buf = s.buf[i+1:]
+		if err := s.logger.log(Entry{Stream: s.name, Line: line}); err != nil {
+			return 0, err
+		}
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	if len(s.buf) > 0 {
+		s.timer = time.AfterFunc(s.logger.mux.timeout, s.flushPartial)
+	}
+	return len(b), nil
+}
+
+func (s *stream) flush() error {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if len(s.buf) == 0 {
+		return nil
+	}
+	line := string(s.buf)
+	s.buf = s.buf[:0]
+	return s.logger.log(Entry{Stream: s.name, Line: line})
+}
+
+// flushPartial writes out a partial line once it has timed out. The end of the
+// line is held back if it could be the start of a secret, since redacting the
+// two halves of a secret separately would mask neither of them.
+func (s *stream) flushPartial() {
+	s.logger.mut.Lock()
+	defer s.logger.mut.Unlock()
+	s.timer = nil
+	hold := s.logger.mux.secrets.Partial(string(s.buf))
+	if hold == len(s.buf) {
+		return
+	}
+	line := string(s.buf[:len(s.buf)-hold])
+	s.buf = append([]byte{}, s.buf[len(s.buf)-hold:]...)
+	s.logger.log(Entry{Stream: s.name, Line: line})
 }
 
-func (w *Logger) Printf(msg string, args ...any) (int, error) {
-	return w.Write([]byte(fmt.Sprintf(msg, args...)))
+func (entry Entry) encode(format LogFormat) string {
+	if format == LogJSON {
+		data, _ := json.Marshal(entry)
+		return string(data) + "\n"
+	}
+	pairs := []string{
+		"time=" + entry.Time.Format(time.RFC3339Nano),
+		"name=" + logfmtValue(entry.Name),
+		"kind=" + entry.Kind,
+	}
+	for _, field := range [][2]string{
+		{"stream", entry.Stream},
+		{"event", entry.Event},
+		{"step", entry.Step},
+		{"cmd", entry.Cmd},
+	} {
+		if field[1] != "" {
+			pairs = append(pairs, field[0]+"="+logfmtValue(field[1]))
+		}
+	}
+	if entry.PID != 0 {
+		pairs = append(pairs, fmt.Sprintf("pid=%v", entry.PID))
+	}
+	if entry.Code != nil {
+		pairs = append(pairs, fmt.Sprintf("code=%v", *entry.Code))
+	}
+	for _, field := range [][2]string{
+		{"duration", entry.Duration},
+		{"error", entry.Error},
+		{"reason", entry.Reason},
+	} {
+		if field[1] != "" {
+			pairs = append(pairs, field[0]+"="+logfmtValue(field[1]))
+		}
+	}
+	if entry.Event == "" {
+		pairs = append(pairs, "line="+logfmtValue(entry.Line))
+	}
+	return strings.Join(pairs, " ") + "\n"
 }
 
-func (w *Logger) Write(b []byte) (int, error) {
-	_, err := w.writer.Write(append([]byte(w.prefix), b...))
-	return len(b), err
+func logfmtValue(val string) string {
+	if val == "" || strings.ContainsAny(val, " =\"\\\t\n") {
+		return strconv.Quote(val)
+	}
+	return val
 }
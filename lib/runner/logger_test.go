@@ -0,0 +1,43 @@
+package runner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogFormatSet(t *testing.T) {
+	var format LogFormat
+	assert.Equal(t, "text", format.String())
+	assert.Nil(t, format.Set("json"))
+	assert.Equal(t, LogJSON, format)
+	assert.NotNil(t, format.Set("xml"))
+	assert.Equal(t, LogJSON, format)
+}
+
+func TestEntryEncodeLogfmt(t *testing.T) {
+	code := 1
+	entry := Entry{
+		Time:     time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC),
+		Name:     "test",
+		Kind:     "task",
+		Event:    "exit",
+		Cmd:      `go test ./...`,
+		Code:     &code,
+		Duration: "2s",
+		Error:    "exit status 1",
+	}
+	assert.Equal(
+		t,
+		`time=2023-03-01T12:00:00Z name=test kind=task event=exit cmd="go test ./..." code=1 duration=2s error="exit status 1"`+"\n",
+		entry.encode(LogFmt),
+	)
+
+	entry = Entry{Time: entry.Time, Name: "web", Kind: "service", Stream: "stdout", Line: `say "hi"`}
+	assert.Equal(
+		t,
+		`time=2023-03-01T12:00:00Z name=web kind=service stream=stdout line="say \"hi\""`+"\n",
+		entry.encode(LogFmt),
+	)
+}
@@ -0,0 +1,134 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/tanema/grind/lib/procfile"
+	"github.com/tanema/grind/lib/term"
+)
+
+const matrixTemplate = `
+{{"Matrix:" | bold | bright}} {{.Name | bold}}
+  {{.Header | faint}}
+{{- range .Rows}}
+  {{.Label | bold}}{{range .Cells}} {{if eq .Status "pass"}}{{.Text | green}}{{else if eq .Status "fail"}}{{.Text | red}}{{else}}{{.Text | faint}}{{end}}{{end}}
+{{- end}}`
+
+type (
+	matrixGrid struct {
+		Name   string
+		Header string
+		Rows   []matrixRow
+	}
+	matrixRow struct {
+		Label string
+		Cells []matrixCell
+	}
+	matrixCell struct {
+		Text   string
+		Status string
+	}
+)
+
+// runMatrix runs an instance of the task for every combination in its matrix
+// in parallel. A failing instance does not stop the others so that the grid at
+// the end shows the full picture.
+func (runner *Runner) runMatrix(ctx context.Context, parent *Process, outputs map[string]string, task *procfile.Service, capture bool, args []string, vars map[string]string) error {
+	instances := task.Expand()
+	errs := make([]error, len(instances))
+	var wg sync.WaitGroup
+	for i, inst := range instances {
+		wg.Add(1)
+		go func(i int, inst *procfile.Service) {
+			defer wg.Done()
+			proc := newProc(runner, inst, parent)
+			proc.ctx = ctx
+			for key, val := range outputs {
+				proc.outputs[key] = val
+			}
+			proc.vars = map[string]string{}
+			for key, val := range vars {
+				proc.vars[key] = val
+			}
+			for key, val := range inst.MatrixValues {
+				proc.vars[key] = val
+			}
+			errs[i] = proc.run(capture, args)
+		}(i, inst)
+	}
+	wg.Wait()
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if capture && !runner.mux.format.structured() {
+		term.NewScreenBuf(runner.stderr).Render(matrixTemplate, buildMatrixGrid(task, instances, errs))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v of %v runs of %v failed", failed, len(instances), task.Name)
+	}
+	return nil
+}
+
+// buildMatrixGrid lays out the results with the values of the last matrix key
+// as columns, and every combination of the other keys as rows.
+func buildMatrixGrid(task *procfile.Service, instances []*procfile.Service, errs []error) matrixGrid {
+	keys := task.MatrixKeys()
+	colKey, rowKeys := keys[len(keys)-1], keys[:len(keys)-1]
+	columns := task.Matrix[colKey]
+	labels := []string{}
+	cells := map[string]map[string]error{}
+	for i, inst := range instances {
+		vals := []string{}
+		for _, key := range rowKeys {
+			vals = append(vals, inst.MatrixValues[key])
+		}
+		label := strings.Join(vals, " ")
+		if label == "" {
+			label = task.Name
+		}
+		if _, ok := cells[label]; !ok {
+			labels = append(labels, label)
+			cells[label] = map[string]error{}
+		}
+		cells[label][inst.MatrixValues[colKey]] = errs[i]
+	}
+	labelWidth := len(strings.Join(rowKeys, " "))
+	for _, label := range labels {
+		if len(label) > labelWidth {
+			labelWidth = len(label)
+		}
+	}
+	widths := []int{}
+	header := []string{fmt.Sprintf("%-*v", labelWidth, strings.Join(rowKeys, " "))}
+	for _, col := range columns {
+		width := utf8.RuneCountInString("✘ fail")
+		if len(col) > width {
+			width = len(col)
+		}
+		widths = append(widths, width)
+		header = append(header, fmt.Sprintf("%-*v", width, col))
+	}
+	grid := matrixGrid{Name: task.Name, Header: strings.Join(header, " ")}
+	for _, label := range labels {
+		row := matrixRow{Label: fmt.Sprintf("%-*v", labelWidth, label)}
+		for i, col := range columns {
+			cell := matrixCell{Text: "-", Status: "none"}
+			if err, ok := cells[label][col]; ok && err != nil {
+				cell = matrixCell{Text: "✘ fail", Status: "fail"}
+			} else if ok {
+				cell = matrixCell{Text: "✔ pass", Status: "pass"}
+			}
+			cell.Text = fmt.Sprintf("%-*v", widths[i], cell.Text)
+			row.Cells = append(row.Cells, cell)
+		}
+		grid.Rows = append(grid.Rows, row)
+	}
+	return grid
+}
@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tanema/grind/lib/procfile"
+)
+
+func TestBuildMatrixGrid(t *testing.T) {
+	task := &procfile.Service{
+		Name:   "test",
+		Matrix: map[string][]string{"go": {"go_1_21", "go_1_22"}, "db": {"mysql", "postgresql"}},
+	}
+	instances := task.Expand()
+	grid := buildMatrixGrid(task, instances, []error{nil, nil, nil, errors.New("failed")})
+	assert.Equal(t, "db         go_1_21 go_1_22", grid.Header)
+	assert.Equal(t, []matrixRow{
+		{Label: "mysql     ", Cells: []matrixCell{{Text: "✔ pass ", Status: "pass"}, {Text: "✔ pass ", Status: "pass"}}},
+		{Label: "postgresql", Cells: []matrixCell{{Text: "✔ pass ", Status: "pass"}, {Text: "✘ fail ", Status: "fail"}}},
+	}, grid.Rows)
+
+	task = &procfile.Service{Name: "lint", Matrix: map[string][]string{"go": {"go_1_21"}}}
+	grid = buildMatrixGrid(task, task.Expand(), []error{nil})
+	assert.Equal(t, []matrixRow{{Label: "lint", Cells: []matrixCell{{Text: "✔ pass ", Status: "pass"}}}}, grid.Rows)
+}
+
+func TestRunMatrixWithHostExecutor(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	runner := hostRunner(t, `version: "1"
+tasks:
+  test:
+    matrix:
+      db: [mysql, postgresql]
+      go: [go_1_21, go_1_22]
+    env:
+      DB_ENGINE: ${db}
+    cmds:
+      - echo "$DB_ENGINE on $go"
+      - test "$DB_ENGINE-$go" != postgresql-go_1_22
+`, Config{Stdout: &stdout, Stderr: &stderr})
+	err := runner.RunTask("test", true, nil, nil)
+	assert.EqualError(t, err, "1 of 4 runs of test failed")
+	for _, line := range []string{"mysql on go_1_21", "mysql on go_1_22", "postgresql on go_1_21", "postgresql on go_1_22"} {
+		assert.Contains(t, stdout.String(), line)
+	}
+	assert.Contains(t, stderr.String(), "Matrix:")
+}
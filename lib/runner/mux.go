@@ -0,0 +1,88 @@
+package runner
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+
+	"github.com/tanema/grind/lib/secrets"
+)
+
+// partialLineTimeout is how long a line without a newline is buffered before
+// it is flushed on its own, so that prompts and progress output still show up.
+const partialLineTimeout = 250 * time.Millisecond
+
+// timestampFormat is the format for timestamps on every line in text output
+const timestampFormat = "15:04:05.000"
+
+// Mux is the central multiplexer for the output of every running process. Each
+// process writes into its own Logger which buffers until a full line has been
+// written, then the mux serializes that line to the terminal so that lines from
+// concurrent processes never interleave. The values of secrets are redacted
+// from every line before it is written.
+type Mux struct {
+	mut        sync.Mutex
+	stdout     io.Writer
+	stderr     io.Writer
+	format     LogFormat
+	timestamps bool
+	timeout    time.Duration
+	secrets    secrets.Redactor
+	events     chan<- Entry
+}
+
+func newMux(stdout, stderr io.Writer, format LogFormat, timestamps bool) *Mux {
+	return &Mux{
+		stdout:     stdout,
+		stderr:     stderr,
+		format:     format,
+		timestamps: timestamps,
+		timeout:    partialLineTimeout,
+	}
+}
+
+// Logger creates a new line buffered logger for a single process.
+func (mux *Mux) Logger(name, kind, prefix string) *Logger {
+	logger := &Logger{mux: mux, name: name, kind: kind, prefix: prefix}
+	logger.stdout = &stream{logger: logger, name: "stdout"}
+	logger.stderr = &stream{logger: logger, name: "stderr"}
+	return logger
+}
+
+// write outputs a line or lifecycle event. In text mode, events are output as
+// their msg instead, and skipped if there is no msg.
+func (mux *Mux) write(prefix string, entry Entry, msg string) error {
+	entry.Time = time.Now()
+	entry.Line = mux.secrets.Redact(entry.Line)
+	entry.Cmd = mux.secrets.Redact(entry.Cmd)
+	entry.Error = mux.secrets.Redact(entry.Error)
+	entry.Reason = mux.secrets.Redact(entry.Reason)
+	// events are sent before locking so that a slow reader only holds up the
+	// process that wrote the entry, rather than every process.
+	if mux.events != nil {
+		mux.events <- entry
+	}
+	mux.mut.Lock()
+	defer mux.mut.Unlock()
+	if mux.format.structured() {
+		_, err := io.WriteString(mux.stdout, entry.encode(mux.format))
+		return err
+	}
+	line := entry.Line
+	if entry.Event != "" {
+		if line = mux.secrets.Redact(msg); line == "" {
+			return nil
+		}
+	}
+	writer := mux.stdout
+	if entry.Stream == "stderr" {
+		writer = mux.stderr
+	}
+	if mux.timestamps {
+		prefix = color.New(color.Faint).Sprint(entry.Time.Format(timestampFormat)) + " " + prefix
+	}
+	_, err := io.WriteString(writer, prefix+line+"\n")
+	return err
+}
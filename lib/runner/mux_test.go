@@ -0,0 +1,175 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer is a buffer that is safe to read while the mux is still writing
+// from timers.
+type syncBuffer struct {
+	mut sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.buf.String()
+}
+
+func TestLoggerPrefixesEveryLine(t *testing.T) {
+	var stdout, stderr syncBuffer
+	logger := newMux(&stdout, &stderr, LogText, false).Logger("web", "service", "web | ")
+	n, err := logger.Stdout().Write([]byte("hello\nworld\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, 12, n)
+	assert.Equal(t, "web | hello\nweb | world\n", stdout.String())
+	assert.Equal(t, "", stderr.String())
+}
+
+func TestLoggerBuffersPartialLines(t *testing.T) {
+	var stdout syncBuffer
+	mux := newMux(&stdout, &stdout, LogText, false)
+	mux.timeout = time.Hour
+	logger := mux.Logger("web", "service", "web | ")
+	logger.Stdout().Write([]byte("hel"))
+	logger.Stdout().Write([]byte("lo\r\nwor"))
+	assert.Equal(t, "web | hello\n", stdout.String())
+	assert.Nil(t, logger.Flush())
+	assert.Equal(t, "web | hello\nweb | wor\n", stdout.String())
+}
+
+func TestLoggerFlushesPartialLinesOnTimeout(t *testing.T) {
+	var stdout syncBuffer
+	mux := newMux(&stdout, &stdout, LogText, false)
+	mux.timeout = 10 * time.Millisecond
+	logger := mux.Logger("web", "service", "web | ")
+	logger.Stdout().Write([]byte("password: "))
+	assert.Eventually(t, func() bool {
+		return stdout.String() == "web | password: \n"
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestLoggerKeepsStreamOrdering(t *testing.T) {
+	var out syncBuffer
+	mux := newMux(&out, &out, LogText, false)
+	mux.timeout = time.Hour
+	logger := mux.Logger("web", "service", "web | ")
+	logger.Stdout().Write([]byte("loading..."))
+	logger.Stderr().Write([]byte("warning\n"))
+	logger.Stdout().Write([]byte("done\n"))
+	assert.Equal(t, "web | loading...\nweb | warning\nweb | done\n", out.String())
+}
+
+func TestLoggerStructured(t *testing.T) {
+	var stdout, stderr syncBuffer
+	logger := newMux(&stdout, &stderr, LogJSON, false).Logger("web", "service", "web | ")
+	logger.Stderr().Write([]byte("hello\nworld\n"))
+	assert.Equal(t, "", stderr.String())
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	assert.Len(t, lines, 2)
+	var entry Entry
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, "web", entry.Name)
+	assert.Equal(t, "service", entry.Kind)
+	assert.Equal(t, "stderr", entry.Stream)
+	assert.Equal(t, "world", entry.Line)
+}
+
+func TestMuxConcurrentWriters(t *testing.T) {
+	var out syncBuffer
+	mux := newMux(&out, &out, LogText, false)
+	mux.timeout = time.Hour
+
+	const procs, lines = 10, 200
+	var wg sync.WaitGroup
+	for p := 0; p < procs; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			name := fmt.Sprintf("proc%v", p)
+			logger := mux.Logger(name, "service", name+" | ")
+			for i := 0; i < lines; i++ {
+				// write each line in several chunks, across both streams, with
+				// some chunks containing multiple lines.
+				line := fmt.Sprintf("%v line %v\n", name, i)
+				half := len(line) / 2
+				writer := logger.Stdout()
+				if i%3 == 0 {
+					writer = logger.Stderr()
+				}
+				writer.Write([]byte(line[:half]))
+				if i%5 == 0 {
+					writer.Write([]byte(line[half:] + fmt.Sprintf("%v extra %v\n", name, i)))
+				} else {
+					writer.Write([]byte(line[half:]))
+				}
+			}
+			logger.Flush()
+		}(p)
+	}
+	wg.Wait()
+
+	counts := map[string]int{}
+	last := map[string]int{}
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		parts := strings.SplitN(line, " | ", 2)
+		assert.Len(t, parts, 2, line)
+		assert.True(t, strings.HasPrefix(parts[1], parts[0]+" "), line)
+		assert.NotContains(t, parts[1], "|", line)
+		var i int
+		if _, err := fmt.Sscanf(parts[1], parts[0]+" line %d", &i); err == nil {
+			assert.Equal(t, last[parts[0]], i, "lines are out of order")
+			last[parts[0]] = i + 1
+		}
+		counts[parts[0]]++
+	}
+	for p := 0; p < procs; p++ {
+		assert.Equal(t, lines+lines/5, counts[fmt.Sprintf("proc%v", p)])
+	}
+}
+
+func TestMuxRedactsSecrets(t *testing.T) {
+	var stdout, stderr syncBuffer
+	mux := newMux(&stdout, &stderr, LogJSON, false)
+	mux.secrets.Add("hunter22")
+	logger := mux.Logger("web", "service", "")
+	logger.Stdout().Write([]byte("password is hunter22\n"))
+	logger.start("login --password hunter22")
+	var line, event Entry
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &line))
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &event))
+	assert.Equal(t, "password is ******", line.Line)
+	assert.Equal(t, "login --password ******", event.Cmd)
+}
+
+func TestLoggerHoldsBackPartialSecrets(t *testing.T) {
+	var stdout syncBuffer
+	mux := newMux(&stdout, &stdout, LogText, false)
+	mux.timeout = 10 * time.Millisecond
+	mux.secrets.Add("hunter22")
+	logger := mux.Logger("web", "service", "web | ")
+	logger.Stdout().Write([]byte("password is hun"))
+	assert.Eventually(t, func() bool {
+		return stdout.String() == "web | password is \n"
+	}, time.Second, 5*time.Millisecond)
+	logger.Stdout().Write([]byte("ter22\n"))
+	assert.Equal(t, "web | password is \nweb | ******\n", stdout.String())
+}
@@ -0,0 +1,119 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tanema/grind/lib/procfile"
+)
+
+const (
+	// readyTimeout is how long to wait for a needed service to become ready
+	readyTimeout = time.Minute
+	// readyInterval is how often the ready check of a service is run
+	readyInterval = 500 * time.Millisecond
+)
+
+// startNeeds starts the services that a task needs and waits until they are
+// ready. Services that are already running in grind run, or were started by a
+// parent task, are reused. The returned func stops the services that were
+// started and runs the after commands of the ones whose before succeeded.
+func (runner *Runner) startNeeds(task *procfile.Service) (func(), error) {
+	if len(task.Needs) == 0 {
+		return func() {}, nil
+	}
+	state, err := ReadState(runner.procfile)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(runner.ctx)
+	var wg sync.WaitGroup
+	started := []*Process{}
+	stop := func() {
+		cancel()
+		wg.Wait()
+		for _, proc := range started {
+			proc.ctx = runner.ctx
+			proc.after(true, nil)
+			runner.needed.Delete(proc.defn.Name)
+		}
+	}
+	for _, name := range task.Needs {
+		if state.Running(name) {
+			log := runner.mux.Logger(name, "service", runner.prefix(name))
+			log.reuse(fmt.Sprintf("using %v already running in grind run (pid %v)", name, state.PID))
+			continue
+		} else if _, loaded := runner.needed.LoadOrStore(name, true); loaded {
+			continue
+		}
+		proc := newProc(runner, runner.procfile.Services[name], nil)
+		proc.ctx = ctx
+		proc.background = true
+		proc.started = make(chan struct{}, 1)
+		if err := proc.before(true, nil); err != nil {
+			runner.needed.Delete(name)
+			stop()
+			return nil, err
+		}
+		started = append(started, proc)
+		exited := make(chan error, 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			exited <- proc.serve(ctx)
+		}()
+		if err := proc.waitReady(exited); err != nil {
+			stop()
+			return nil, err
+		}
+	}
+	return stop, nil
+}
+
+// waitReady polls the ready check of a service until it passes. Services
+// without a ready check are ready as soon as their command has started.
+func (proc *Process) waitReady(exited chan error) error {
+	if proc.defn.Ready == "" {
+		select {
+		case <-proc.started:
+			return nil
+		case err := <-exited:
+			select {
+			case <-proc.started:
+				return nil
+			default:
+				return proc.exitedEarly(err)
+			}
+		case <-proc.ctx.Done():
+			return proc.ctx.Err()
+		}
+	}
+	check := &procfile.Command{Run: proc.defn.Ready}
+	timeout := time.After(readyTimeout)
+	for {
+		if ok, err := proc.test(proc.ctx, check, check.Run, nil, nil); err != nil {
+			return err
+		} else if ok {
+			proc.log.healthy()
+			return nil
+		}
+		select {
+		case err := <-exited:
+			return proc.exitedEarly(err)
+		case <-timeout:
+			return fmt.Errorf("%v was not ready after %v", proc.defn.Name, readyTimeout)
+		case <-proc.ctx.Done():
+			return proc.ctx.Err()
+		case <-time.After(readyInterval):
+		}
+	}
+}
+
+func (proc *Process) exitedEarly(err error) error {
+	if err == nil {
+		err = fmt.Errorf("exited before it was ready")
+	}
+	return fmt.Errorf("%v %v", proc.defn.Name, err)
+}
@@ -1,157 +1,508 @@
 package runner
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/fatih/color"
+	"github.com/tanema/grind/lib/expand"
 	"github.com/tanema/grind/lib/procfile"
 )
 
 // Process captures a single running process
 type Process struct {
 	runner *Runner
+	ctx    context.Context
 	defn   *procfile.Service
-	prefix string
-}
-
-var (
-	colorIndex = 0
-	logColors  = []*color.Color{
-		color.New(color.FgHiYellow),
-		color.New(color.FgHiBlue),
-		color.New(color.FgHiMagenta),
-		color.New(color.FgHiCyan),
-		color.New(color.FgHiWhite),
-		color.New(color.FgYellow),
-		color.New(color.FgBlue),
-		color.New(color.FgMagenta),
-		color.New(color.FgCyan),
+	log    *Logger
+	runs   int
+	lane   int
+	vars   map[string]string
+	// outputs are the captured values passed in by the task that called this
+	// one, and captures are every value captured by this process, which its
+	// outputs are passed back to the caller from.
+	outputs    map[string]string
+	captures   map[string]string
+	background bool
+	// started receives once a command has started, if it is set
+	started chan struct{}
+}
+
+var logColors = []*color.Color{
+	color.New(color.FgHiYellow),
+	color.New(color.FgHiBlue),
+	color.New(color.FgHiMagenta),
+	color.New(color.FgHiCyan),
+	color.New(color.FgHiWhite),
+	color.New(color.FgYellow),
+	color.New(color.FgBlue),
+	color.New(color.FgMagenta),
+	color.New(color.FgCyan),
+}
+
+func newProc(run *Runner, service *procfile.Service, parent *Process) *Process {
+	kind := "service"
+	if service.IsTask {
+		kind = "task"
 	}
-)
+	run.mux.secrets.Add(service.Secrets()...)
+	proc := &Process{
+		runner:   run,
+		ctx:      run.ctx,
+		defn:     service,
+		log:      run.mux.Logger(service.Name, kind, run.prefix(service.Name)),
+		outputs:  map[string]string{},
+		captures: map[string]string{},
+	}
+	if parent != nil {
+		proc.lane = parent.lane
+	} else {
+		proc.lane = run.tracer.lane(service.Name)
+	}
+	return proc
+}
+
+// prefix is the colored name that every line of output of a process starts with
+func (runner *Runner) prefix(name string) string {
+	return runner.nextColor().Sprintf("%*v | ", runner.titleLen, name)
+}
 
-func newProc(run *Runner, service *procfile.Service) *Process {
-	colorIndex = (colorIndex + 1) % len(logColors)
-	return &Process{
-		runner: run,
-		defn:   service,
-		prefix: logColors[colorIndex].Sprintf("%*v | ", run.titleLen, service.Name),
+// nextColor picks the color of the prefix of the next process, cycling through
+// the colors as processes are started.
+func (runner *Runner) nextColor() *color.Color {
+	runner.colorMut.Lock()
+	defer runner.colorMut.Unlock()
+	runner.colors = (runner.colors + 1) % len(logColors)
+	return logColors[runner.colors]
+}
+
+// nixShell builds the command that will run a shell command within the
+// environment of the process with the executor of the runner, with the dir and
+// env overrides of the command, and the outputs captured so far. An empty shell
+// command starts an interactive shell.
+func (proc *Process) nixShell(ctx context.Context, cmd *procfile.Command, shellCmd string, args []string, outputs map[string]string) (*exec.Cmd, error) {
+	keep := proc.defn.EnvKeys()
+	for key := range outputs {
+		keep = append(keep, key)
+	}
+	for key := range cmd.Env {
+		keep = append(keep, key)
 	}
+	dir, err := proc.path(cmd, cmd.Dir, args, outputs)
+	if err != nil {
+		return nil, err
+	}
+	cmdProc := proc.runner.executor.Command(ctx, proc.defn, shellCmd, keep)
+	cmdProc.Dir = dir
+	cmdProc.Env = proc.defn.Environ()
+	for key, val := range proc.defn.ArgEnv(proc.vars) {
+		cmdProc.Env = append(cmdProc.Env, key+"="+val)
+	}
+	for key, val := range outputs {
+		cmdProc.Env = append(cmdProc.Env, key+"="+val)
+	}
+	for key, val := range cmd.Env {
+		val, err := proc.expandEnv(val, args, nil, outputs)
+		if err != nil {
+			return nil, err
+		}
+		cmdProc.Env = append(cmdProc.Env, key+"="+val)
+	}
+	return cmdProc, nil
 }
 
-func (proc *Process) command(cmd string, captured bool, args []string) error {
+func (proc *Process) command(ctx context.Context, step string, command *procfile.Command, captured bool, args []string, outputs map[string]string) error {
 	var shutdownStart time.Time
-	nixCmd := []string{`<nixpkgs>`}
-	if proc.defn.Isolated {
-		nixCmd = append(nixCmd, "--pure")
-		for _, key := range proc.defn.EnvKeys() {
-			nixCmd = append(nixCmd, "--keep", key)
+	var shellCmd string
+	cmd := command.Run
+	tracing := cmd != "" && captured && proc.runner.tracer != nil
+	if cmd != "" {
+		var err error
+		if shellCmd, err = proc.expandEnv(cmd, args, command.Env, outputs); err != nil {
+			return err
 		}
 	}
-	nixCmd = append(nixCmd, append([]string{"--packages"}, proc.defn.Nixpkgs...)...)
-	if cmd != "" {
-		nixCmd = append(nixCmd, "--command", proc.expandEnv(cmd, args))
+	if command.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, command.Timeout)
+		defer cancel()
 	}
-	cmdProc := exec.CommandContext(proc.runner.ctx, "nix-shell", nixCmd...)
-	cmdProc.Dir = proc.defn.Dir
-	cmdProc.Stdin = os.Stdin
+	cmdProc, err := proc.nixShell(ctx, command, shellCmd, args, outputs)
+	if err != nil {
+		return err
+	}
+	var ready *readyPipe
+	if tracing {
+		// the time spent setting up the environment, like nix evaluating the
+		// packages, is traced separately from the command.
+		if ready, err = proc.watchReady(cmdProc); err != nil {
+			return err
+		}
+	}
+	cmdProc.Stdin = proc.runner.input
 	cmdProc.SysProcAttr = &syscall.SysProcAttr{Setpgid: captured}
-	cmdProc.Env = proc.defn.Environ()
 	cmdProc.WaitDelay = time.Minute
 	cmdProc.Cancel = func() error {
 		if captured {
-			fmt.Fprintln(cmdProc.Stdout, color.CyanString("stopping..."))
+			proc.log.stopping(cmd)
 		}
 		shutdownStart = time.Now()
 		return syscall.Kill(-cmdProc.Process.Pid, syscall.SIGKILL)
 	}
-	cmdProc.Stdout = os.Stdout
-	cmdProc.Stderr = os.Stderr
+	cmdProc.Stdout = proc.runner.stdout
+	cmdProc.Stderr = proc.runner.stderr
+	var tty *ptyTerm
+	var stdin io.WriteCloser
 	if captured {
-		cmdProc.Stdout = &Logger{prefix: proc.prefix, writer: os.Stdout}
-		cmdProc.Stderr = &Logger{prefix: proc.prefix, writer: os.Stderr}
-		fmt.Fprintf(cmdProc.Stdout, "🚀 => %v\n", cmd)
+		var err error
+		cmdProc.Stdout = proc.log.Stdout()
+		cmdProc.Stderr = proc.log.Stderr()
+		if proc.defn.TTY && command.Capture == "" {
+			if tty, err = proc.openPty(cmdProc); err != nil {
+				return err
+			}
+		} else if proc.background {
+			cmdProc.Stdin = nil
+		} else if proc.runner.stdin != nil {
+			cmdProc.Stdin = nil
+			if stdin, err = cmdProc.StdinPipe(); err != nil {
+				return err
+			}
+		}
+		proc.log.start(cmd)
+	}
+	var output bytes.Buffer
+	if command.Capture != "" {
+		cmdProc.Stdout = io.MultiWriter(cmdProc.Stdout, &output)
+	}
+	cmdSpan := proc.runner.tracer.span(proc.lane, "cmd", proc.runner.mux.secrets.Redact(cmd), map[string]any{"name": proc.defn.Name, "step": step})
+	defer cmdSpan.end()
+	var nixSpan *span
+	if ready != nil {
+		nixSpan = proc.runner.tracer.span(proc.lane, "nix", "nix-shell", map[string]any{"packages": proc.defn.Nixpkgs})
 	}
-	err := cmdProc.Run()
-	exited := false
+	start := time.Now()
+	err = cmdProc.Start()
+	if ready != nil {
+		ready.start(nixSpan.end)
+	}
+	if err == nil {
+		if tty != nil {
+			tty.start()
+		} else if stdin != nil {
+			proc.runner.stdin.register(proc.defn.Name, stdin, false)
+			defer proc.runner.stdin.unregister(proc.defn.Name)
+		}
+		if captured {
+			proc.log.ready(cmd, cmdProc.Process.Pid)
+		}
+		select {
+		case proc.started <- struct{}{}:
+		default:
+		}
+		err = cmdProc.Wait()
+	}
+	if ready != nil {
+		// the signal is handled before the trace can be written
+		ready.wait()
+	}
+	if tty != nil {
+		tty.close()
+	}
+	duration := time.Since(start)
+	status := "ok"
+	code := 0
 	if exitErr, ok := err.(*exec.ExitError); ok {
-		status := exitErr.ProcessState.Sys().(syscall.WaitStatus)
-		signal := status.Signal()
-		if signal == syscall.SIGKILL || signal == syscall.SIGINT {
+		code = exitErr.ExitCode()
+		status = "failed"
+		wstatus := exitErr.ProcessState.Sys().(syscall.WaitStatus)
+		signal := wstatus.Signal()
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %v", command.Timeout)
+		} else if signal == syscall.SIGKILL || signal == syscall.SIGINT {
 			err = nil
-			exited = true
+			status = "stopped"
 		}
+	} else if err != nil {
+		code = -1
+		status = "failed"
+	}
+	if command.Capture != "" && status == "ok" {
+		outputs[command.Capture] = strings.TrimSpace(output.String())
+		proc.captures[command.Capture] = outputs[command.Capture]
 	}
 	if captured {
+		var msg string
 		if err != nil {
-			fmt.Fprintln(cmdProc.Stdout, color.RedString("🔥 exited with error:"), err)
-		} else if exited {
-			fmt.Fprintf(cmdProc.Stdout, color.GreenString("✅ exited successfully in %v.\n"), time.Now().Sub(shutdownStart))
+			msg = fmt.Sprintf("%v %v", color.RedString("🔥 exited with error:"), err)
+		} else if status == "stopped" {
+			msg = color.GreenString("✅ stopped after %v, shutdown took %v.", duration, time.Since(shutdownStart))
 		} else {
-			fmt.Fprintf(cmdProc.Stdout, color.GreenString("✅ completed successfully in %v.\n"), time.Now().Sub(shutdownStart))
+			msg = color.GreenString("✅ completed successfully in %v.", duration)
 		}
+		proc.log.exit(cmd, code, duration, err, msg)
+		proc.runner.report.add(Result{
+			Name:     proc.defn.Name,
+			Kind:     proc.log.kind,
+			Step:     step,
+			Cmd:      proc.runner.mux.secrets.Redact(cmd),
+			Code:     code,
+			Status:   status,
+			Run:      proc.runs,
+			Started:  start,
+			Duration: duration,
+		})
 	}
 	return err
 }
 
 func (proc *Process) run(capture bool, args []string) error {
+	redacted := []string{}
+	for _, arg := range args {
+		redacted = append(redacted, proc.runner.mux.secrets.Redact(arg))
+	}
+	span := proc.runner.tracer.span(proc.lane, proc.log.kind, proc.defn.Name, map[string]any{"args": redacted})
+	defer span.end()
 	if err := proc.before(capture, args); err != nil {
 		return err
 	}
 	defer proc.after(capture, args)
-	return proc.cmd(capture, args)
+	return proc.cmd(proc.ctx, capture, args)
 }
 
 func (proc *Process) before(capture bool, args []string) error {
-	return proc.runlist(proc.defn.Before, args, capture)
+	if err := proc.render(capture); err != nil {
+		return err
+	}
+	return proc.runlist(proc.ctx, "before", proc.defn.Before, args, capture)
+}
+
+// render renders the templates of the service, before anything else runs
+func (proc *Process) render(capture bool) error {
+	if len(proc.defn.Templates) == 0 {
+		return nil
+	} else if err := proc.defn.RenderTemplates(proc.ctx, proc.runner.executor); err != nil {
+		return fmt.Errorf("%v could not render its templates: %v", proc.defn.Name, err)
+	}
+	if capture {
+		files := []string{}
+		for _, tmpl := range proc.defn.Templates {
+			files = append(files, filepath.Base(tmpl.Dest))
+		}
+		proc.log.render(files)
+	}
+	return nil
 }
 
 func (proc *Process) after(capture bool, args []string) error {
-	return proc.runlist(proc.defn.After, args, capture)
+	return proc.runlist(proc.ctx, "after", proc.defn.After, args, capture)
 }
 
-func (proc *Process) cmd(capture bool, args []string) error {
-	return proc.runlist(proc.defn.Cmd, args, capture)
+func (proc *Process) cmd(ctx context.Context, capture bool, args []string) error {
+	proc.runs++
+	return proc.runlist(ctx, "cmds", proc.defn.Cmd, args, capture)
 }
 
-func (proc *Process) runlist(cmds, args []string, capture bool) error {
+// runlist runs a list of commands in order. Values captured by a command, or
+// passed back by a task it calls, are only seen by the commands after it in the
+// same list, on top of the outputs passed in by the caller of the process.
+func (proc *Process) runlist(ctx context.Context, step string, cmds []*procfile.Command, args []string, capture bool) error {
+	if len(cmds) > 0 {
+		span := proc.runner.tracer.span(proc.lane, "step", step, map[string]any{"name": proc.defn.Name})
+		defer span.end()
+	}
+	outputs := map[string]string{}
+	for key, val := range proc.outputs {
+		outputs[key] = val
+	}
+	start := time.Now()
+	var err error
 	for _, cmd := range cmds {
-		if strings.HasPrefix(cmd, ".@") {
-			if err := proc.runner.RunTask(strings.TrimPrefix(cmd, ".@"), capture, args); err != nil {
-				return err
+		var run bool
+		var reason string
+		if run, reason, err = proc.guard(ctx, cmd, args, outputs); err != nil {
+			break
+		} else if !run {
+			if capture {
+				proc.log.skip(cmd.Run, reason)
 			}
-		} else if err := proc.command(cmd, capture, args); err != nil {
+			continue
+		}
+		if err = proc.runCommand(ctx, step, cmd, args, capture, outputs); err != nil {
+			break
+		}
+	}
+	if capture && len(cmds) > 1 {
+		proc.log.step(step, time.Since(start), err)
+	}
+	return err
+}
+
+// runCommand runs a single command or task, retrying it if it fails and
+// ignoring the error if it was marked with ignore_error.
+func (proc *Process) runCommand(ctx context.Context, step string, cmd *procfile.Command, args []string, capture bool, outputs map[string]string) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if task, ok := cmd.Task(); ok {
+			err = proc.runner.runTask(ctx, proc, outputs, task, capture, args, proc.vars)
+		} else {
+			err = proc.command(ctx, step, cmd, capture, args, outputs)
+		}
+		if err == nil || attempt >= cmd.Retries || ctx.Err() != nil {
+			break
+		}
+		if capture {
+			proc.log.retry(cmd.Run, attempt+1, cmd.Retries, cmd.RetryDelay)
+		}
+		select {
+		case <-time.After(cmd.RetryDelay):
+		case <-ctx.Done():
 			return err
 		}
 	}
-	return nil
+	if err != nil && cmd.IgnoreError {
+		if capture {
+			proc.log.ignored(cmd.Run, err)
+		}
+		return nil
+	}
+	return err
+}
+
+// guard checks the conditions on a command to see if it should be run, returning
+// the reason it should be skipped if not.
+func (proc *Process) guard(ctx context.Context, cmd *procfile.Command, args []string, outputs map[string]string) (bool, string, error) {
+	if !cmd.OnPlatform() {
+		return false, fmt.Sprintf("only runs on %v", strings.Join(cmd.Platforms, ", ")), nil
+	}
+	if cmd.Exists != "" {
+		if exists, err := proc.pathExists(cmd, cmd.Exists, args, outputs); err != nil || !exists {
+			return false, fmt.Sprintf("%v does not exist", cmd.Exists), err
+		}
+	}
+	if cmd.Missing != "" {
+		if exists, err := proc.pathExists(cmd, cmd.Missing, args, outputs); err != nil || exists {
+			return false, fmt.Sprintf("%v already exists", cmd.Missing), err
+		}
+	}
+	if cmd.If != "" {
+		if ok, err := proc.test(ctx, cmd, cmd.If, args, outputs); err != nil {
+			return false, "", err
+		} else if !ok {
+			return false, fmt.Sprintf("if: %v failed", cmd.If), nil
+		}
+	}
+	if cmd.Unless != "" {
+		if ok, err := proc.test(ctx, cmd, cmd.Unless, args, outputs); err != nil {
+			return false, "", err
+		} else if ok {
+			return false, fmt.Sprintf("unless: %v succeeded", cmd.Unless), nil
+		}
+	}
+	return true, "", nil
+}
+
+// test runs a shell test in the environment of the process, returning true if
+// it exits successfully and false if it exits with an error. Failing to set up
+// the environment, or being stopped, is an error rather than false.
+func (proc *Process) test(ctx context.Context, cmd *procfile.Command, cond string, args []string, outputs map[string]string) (bool, error) {
+	cond, err := proc.expandEnv(cond, args, cmd.Env, outputs)
+	if err != nil {
+		return false, err
+	}
+	cmdProc, err := proc.nixShell(ctx, cmd, cond, args, outputs)
+	if err != nil {
+		return false, err
+	}
+	ready, err := proc.watchReady(cmdProc)
+	if err != nil {
+		return false, err
+	}
+	var stderr bytes.Buffer
+	cmdProc.Stderr = &stderr
+	err = cmdProc.Start()
+	if ready != nil {
+		ready.start(nil)
+	}
+	if err == nil {
+		err = cmdProc.Wait()
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	} else if !ok {
+		return err == nil, err
+	} else if !exitErr.Exited() {
+		return false, fmt.Errorf("%v was stopped: %v", cond, err)
+	} else if ready != nil && !ready.wait() {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return false, fmt.Errorf("could not setup the env for %v: %v: %v", cond, err, msg)
+		}
+		return false, fmt.Errorf("could not setup the env for %v: %v", cond, err)
+	}
+	return false, nil
+}
+
+func (proc *Process) pathExists(cmd *procfile.Command, path string, args []string, outputs map[string]string) (bool, error) {
+	path, err := proc.path(cmd, path, args, outputs)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	return err == nil, nil
+}
+
+// path expands a path and resolves it relative to the dir of the process
+func (proc *Process) path(cmd *procfile.Command, path string, args []string, outputs map[string]string) (string, error) {
+	path, err := proc.expandEnv(path, args, cmd.Env, outputs)
+	if err != nil || filepath.IsAbs(path) {
+		return path, err
+	}
+	return filepath.Join(proc.defn.Dir, path), nil
 }
 
 // runCmd will run a command with the ability to gracefully stop it.
 func (proc *Process) exec(cmd string) error {
-	return proc.command(cmd, false, nil)
+	return proc.command(proc.ctx, "", &procfile.Command{Run: cmd}, false, nil, nil)
 }
 
 func (proc *Process) shell() error {
-	return proc.command("", false, nil)
+	return proc.command(proc.ctx, "", &procfile.Command{}, false, nil, nil)
 }
 
-func (proc *Process) expandEnv(cmd string, args []string) string {
-	cfg := map[string]string{}
-	cfg["$@"] = strings.Join(args, " ")
+// expandEnv interpolates args, captured outputs, and env vars into a string, env
+// is the command level env which takes precedence over the env of the service.
+func (proc *Process) expandEnv(str string, args []string, env, outputs map[string]string) (string, error) {
+	cfg := proc.defn.ArgEnv(proc.vars)
+	for key, val := range proc.vars {
+		cfg[key] = val
+	}
+	cfg["@"] = strings.Join(args, " ")
 	for i, arg := range args {
 		cfg[fmt.Sprintf("%v", i+1)] = arg
 	}
-	return os.Expand(cmd, func(v string) string {
-		if val, ok := proc.defn.Env[v]; ok {
-			return val
-		} else if val, ok := cfg[v]; ok {
-			return val
-		}
-		return os.Getenv(v)
-	})
+	expanded, err := expand.Expander{
+		Lookup: func(name string) (string, bool) {
+			for _, vars := range []map[string]string{cfg, outputs, env, proc.defn.Env} {
+				if val, ok := vars[name]; ok {
+					return val, true
+				}
+			}
+			return os.LookupEnv(name)
+		},
+		Assign:      func(name, val string) { cfg[name] = val },
+		Passthrough: true,
+	}.Expand(str)
+	if err != nil {
+		return "", fmt.Errorf("%v: %v", str, err)
+	}
+	return expanded, nil
 }
@@ -0,0 +1,148 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tanema/grind/lib/procfile"
+)
+
+func TestProcessTest(t *testing.T) {
+	var stdout bytes.Buffer
+	runner := hostRunner(t, `version: "1"
+services:
+  web:
+    cmds: [echo]
+`, Config{Stdout: &stdout, Stderr: &stdout})
+	runner.executor = NixExecutor{}
+	proc := newProc(runner, runner.procfile.Services["web"], nil)
+	check := &procfile.Command{}
+
+	fakeNixShell(t, "")
+	ok, err := proc.test(context.Background(), check, "true", nil, nil)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = proc.test(context.Background(), check, "exit 1", nil, nil)
+	assert.Nil(t, err, "a plain non-zero exit is false")
+	assert.False(t, ok)
+	ok, err = proc.test(context.Background(), check, "if [", nil, nil)
+	assert.Nil(t, err, "a syntax error in the condition is false")
+	assert.False(t, ok)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = proc.test(ctx, check, "true", nil, nil)
+	assert.Equal(t, context.Canceled, err)
+
+	_, err = proc.test(context.Background(), check, "kill -9 $$$$", nil, nil)
+	assert.ErrorContains(t, err, "kill -9 $$ was stopped")
+
+	fakeNixShell(t, `echo "error: undefined variable 'nope'" >&2; exit 1`)
+	_, err = proc.test(context.Background(), check, "true", nil, nil)
+	assert.EqualError(t, err, "could not setup the env for true: exit status 1: error: undefined variable 'nope'")
+}
+
+func TestGuardSurfacesNixFailures(t *testing.T) {
+	fakeNixShell(t, "exit 1")
+	var stdout bytes.Buffer
+	runner := hostRunner(t, `version: "1"
+tasks:
+  build:
+    cmds:
+      - run: echo built
+        if: test -f go.mod
+`, Config{Stdout: &stdout, Stderr: &stdout})
+	runner.executor = NixExecutor{}
+	require.ErrorContains(t, runner.RunTask("build", true, nil, nil), "could not setup the env for test -f go.mod")
+	assert.NotContains(t, stdout.String(), "skipped")
+}
+
+func TestRunCommandOptions(t *testing.T) {
+	var stdout bytes.Buffer
+	runner := hostRunner(t, `version: "1"
+tasks:
+  slow:
+    cmds:
+      - run: sleep 5
+        timeout: 100ms
+  flaky:
+    cmds:
+      - run: echo x >> attempts; test $(wc -l < attempts) -ge 3
+        retries: 2
+        retry_delay: 10ms
+  broken:
+    cmds:
+      - run: exit 2
+        retries: 1
+        retry_delay: 10ms
+  lenient:
+    cmds:
+      - run: exit 4
+        ignore_error: true
+      - echo after
+`, Config{Stdout: &stdout, Stderr: &stdout})
+
+	start := time.Now()
+	assert.EqualError(t, runner.RunTask("slow", true, nil, nil), "timed out after 100ms")
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	require.Nil(t, runner.RunTask("flaky", true, nil, nil))
+	assert.Contains(t, stdout.String(), "retrying in 10ms, attempt 2 of 2.")
+
+	assert.EqualError(t, runner.RunTask("broken", true, nil, nil), "exit status 2")
+
+	require.Nil(t, runner.RunTask("lenient", true, nil, nil))
+	assert.Contains(t, stdout.String(), "ignoring error: exit status 4")
+	assert.Contains(t, stdout.String(), "lenient | after\n")
+
+	runs := map[string]int{}
+	for _, result := range runner.Report().Results() {
+		runs[result.Name]++
+	}
+	assert.Equal(t, map[string]int{"slow": 1, "flaky": 3, "broken": 2, "lenient": 2}, runs)
+}
+
+func TestCapturedOutputs(t *testing.T) {
+	var stdout bytes.Buffer
+	runner := hostRunner(t, `version: "1"
+tasks:
+  sha:
+    outputs: [SHA]
+    cmds:
+      - run: echo "abc123 $$BRANCH"
+        capture: SHA
+      - run: echo private
+        capture: PRIVATE
+  release:
+    before:
+      - run: echo main
+        capture: BRANCH
+      - .@sha
+      - echo "before sha=$SHA"
+    cmds:
+      - echo "cmds sha=${SHA:-unset} private=${PRIVATE:-unset}"
+`, Config{Stdout: &stdout, Stderr: &stdout})
+	require.Nil(t, runner.RunTask("release", true, nil, nil))
+	assert.Contains(t, stdout.String(), "release | before sha=abc123 main\n", "outputs are passed back, and callers pass theirs down")
+	assert.Contains(t, stdout.String(), "release | cmds sha=unset private=unset\n", "captures only last for their runlist")
+}
+
+func TestShellOnlySubstitutions(t *testing.T) {
+	var stdout bytes.Buffer
+	runner := hostRunner(t, `version: "1"
+tasks:
+  build:
+    env:
+      FILE: grind.yml
+    cmds:
+      - echo "${FILE%.yml} ${#FILE} ${FILE:0:5} ${FILE:-none}"
+`, Config{Stdout: &stdout, Stderr: &stdout})
+	runner.executor = HostExecutor{Shell: "bash"}
+	require.Nil(t, runner.RunTask("build", true, nil, nil))
+	assert.Contains(t, stdout.String(), "build | grind 9 grind grind.yml\n")
+}
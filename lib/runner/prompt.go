@@ -0,0 +1,63 @@
+//go:build !windows
+// +build !windows
+
+package runner
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/tanema/grind/lib/procfile"
+	"github.com/tanema/grind/lib/term"
+)
+
+// ask will get confirmation and prompt for the values that a task needs before
+// it is run. With --yes, or when there is no terminal to ask on, confirmations
+// are accepted or rejected and prompts fall back to their defaults so that the
+// outcome is always the same.
+func (runner *Runner) ask(task *procfile.Service, vars map[string]string) error {
+	if task.Confirm == "" && len(task.Prompts) == 0 {
+		return nil
+	}
+	var prompter *term.Prompter
+	if file, ok := runner.input.(*os.File); ok && !runner.yes && runner.stdin == nil && term.IsTerminal(int(file.Fd())) {
+		var err error
+		if prompter, err = term.NewPrompter(file, runner.stderr); err != nil {
+			return err
+		}
+	}
+	if task.Confirm != "" && !runner.yes {
+		if prompter == nil {
+			return fmt.Errorf("%v requires confirmation, rerun with --yes to confirm", task.Name)
+		} else if ok, err := prompter.Confirm(task.Confirm); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("%v was cancelled", task.Name)
+		}
+	}
+	for _, prompt := range task.Prompts {
+		if _, ok := vars[prompt.Name]; ok {
+			continue
+		} else if prompter == nil && prompt.Default == "" {
+			return fmt.Errorf("%v requires a value for %v, run it in a terminal or give the prompt a default", task.Name, prompt.Name)
+		} else if prompter == nil {
+			vars[prompt.Name] = prompt.Default
+			continue
+		}
+		var val string
+		var err error
+		switch prompt.Type {
+		case "select":
+			val, err = prompter.Select(prompt.Message, prompt.Options, prompt.Default)
+		case "password":
+			val, err = prompter.Password(prompt.Message)
+		default:
+			val, err = prompter.Input(prompt.Message, prompt.Default)
+		}
+		if err != nil {
+			return err
+		}
+		vars[prompt.Name] = val
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+//go:build !windows
+// +build !windows
+
+package runner
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+const (
+	defaultTermWidth  = 80
+	defaultTermHeight = 24
+	// ptyDrainTimeout is how long to wait for the remaining output of a pty after
+	// the process exits, in case a background process is still holding it open.
+	ptyDrainTimeout = time.Second
+	// stdinPollInterval is how often copying stdin into a pty checks if it
+	// should stop while there is no input.
+	stdinPollInterval = 100 * time.Millisecond
+)
+
+// ptyTerm is a pseudo-terminal attached to a single command so that tools still
+// output colors and progress bars while their output is captured.
+type ptyTerm struct {
+	proc    *Process
+	ptmx    *os.File
+	tty     *os.File
+	sigc    chan os.Signal
+	done    chan struct{}
+	started bool
+}
+
+func (proc *Process) openPty(cmdProc *exec.Cmd) (*ptyTerm, error) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return nil, err
+	}
+	cmdProc.Stdin = tty
+	cmdProc.Stdout = tty
+	cmdProc.Stderr = tty
+	cmdProc.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	t := &ptyTerm{
+		proc: proc,
+		ptmx: ptmx,
+		tty:  tty,
+		sigc: make(chan os.Signal, 1),
+		done: make(chan struct{}),
+	}
+	t.resize()
+	return t, nil
+}
+
+// start should be called once the command has started. It will start copying
+// output into the logger and keep the pty size in sync with the terminal.
+func (t *ptyTerm) start() {
+	t.started = true
+	t.tty.Close()
+	signal.Notify(t.sigc, syscall.SIGWINCH)
+	go func() {
+		for range t.sigc {
+			t.resize()
+		}
+	}()
+	if stdin := t.proc.runner.stdin; stdin != nil {
+		stdin.register(t.proc.defn.Name, t.ptmx, true)
+	} else {
+		go copyStdin(t.ptmx, t.proc.runner.input, t.done)
+	}
+	go func() {
+		// reading the pty returns EIO once the process has exited, which is
+		// the expected way for this copy to end.
+		io.Copy(t.proc.log.Stdout(), t.ptmx)
+		close(t.done)
+	}()
+}
+
+// copyStdin copies stdin into the pty until done is closed
+func copyStdin(dst io.Writer, src io.Reader, done <-chan struct{}) {
+	buf := make([]byte, 1024)
+	for {
+		n, err := readInput(src, buf, done)
+		if err != nil {
+			return
+		} else if _, err := dst.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// readInput reads from in once it has input, returning io.EOF once done is
+// closed. Files are only read once they have input so that no read is left
+// waiting on the terminal after it is not needed anymore, which would steal the
+// next keys from whatever runs after it. Other readers can only stop once they
+// return.
+func readInput(in io.Reader, buf []byte, done <-chan struct{}) (int, error) {
+	file, ok := in.(*os.File)
+	fds := []unix.PollFd{}
+	if ok {
+		fds = append(fds, unix.PollFd{Fd: int32(file.Fd()), Events: unix.POLLIN})
+	}
+	for {
+		select {
+		case <-done:
+			return 0, io.EOF
+		default:
+		}
+		if !ok {
+			return in.Read(buf)
+		} else if n, err := unix.Poll(fds, int(stdinPollInterval.Milliseconds())); err == unix.EINTR || n == 0 {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		return file.Read(buf)
+	}
+}
+
+// close will wait for the remaining output then release the pty
+func (t *ptyTerm) close() {
+	if t.started {
+		if stdin := t.proc.runner.stdin; stdin != nil {
+			stdin.unregister(t.proc.defn.Name)
+		}
+		signal.Stop(t.sigc)
+		close(t.sigc)
+		select {
+		case <-t.done:
+		case <-time.After(ptyDrainTimeout):
+		}
+	}
+	t.ptmx.Close()
+	t.tty.Close()
+}
+
+// resize sets the pty to the size of the terminal, minus the width that is
+// taken up by the prefix of every line.
+func (t *ptyTerm) resize() {
+	cols, rows, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || cols <= 0 || rows <= 0 {
+		cols, rows = defaultTermWidth, defaultTermHeight
+	}
+	if !t.proc.runner.mux.format.structured() {
+		cols -= t.proc.runner.titleLen + len(" | ")
+	}
+	if cols < 1 {
+		cols = 1
+	}
+	pty.Setsize(t.ptmx, &pty.Winsize{Rows: uint16(rows), Cols: uint16(cols)})
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+// +build !windows
+
+package runner
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyStdinStops(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	require.Nil(t, err)
+	defer reader.Close()
+	defer writer.Close()
+	var out syncBuffer
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		copyStdin(&out, reader, done)
+		close(stopped)
+	}()
+	writer.Write([]byte("ls\n"))
+	assert.Eventually(t, func() bool { return out.String() == "ls\n" }, time.Second, 5*time.Millisecond)
+	close(done)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("did not stop copying once the pty closed")
+	}
+	writer.Write([]byte("q"))
+	buf := make([]byte, 1)
+	_, err = reader.Read(buf)
+	require.Nil(t, err)
+	assert.Equal(t, "q", string(buf), "input after the pty closes is left for the next reader")
+}
@@ -0,0 +1,135 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tanema/grind/lib/term"
+)
+
+const (
+	// maxSummaryCmdLen is the longest a command will be displayed in the summary
+	maxSummaryCmdLen = 40
+	summaryTemplate  = `
+{{"Summary:" | bold | bright}}
+  {{.Header | faint}}
+{{- range .Rows}}
+  {{.Name | bold}} {{.Step | faint}} {{.Cmd}} {{if eq .Status "failed"}}{{.Code | red}}{{else if eq .Status "stopped"}}{{.Code | cyan}}{{else}}{{.Code | green}}{{end}} {{.Duration}} {{.Restarts}}
+{{- end}}`
+)
+
+type (
+	// Result is the outcome of a single command that was run for a service or task
+	Result struct {
+		Name     string
+		Kind     string
+		Step     string
+		Cmd      string
+		Code     int
+		Status   string
+		Run      int
+		Started  time.Time
+		Duration time.Duration
+	}
+	// Report collects the results of every command run so that a summary can be
+	// output once everything has finished.
+	Report struct {
+		mut     sync.Mutex
+		results []Result
+	}
+	summaryRow struct {
+		Name, Step, Cmd, Code, Status, Duration, Restarts string
+	}
+)
+
+func (report *Report) add(result Result) {
+	report.mut.Lock()
+	defer report.mut.Unlock()
+	report.results = append(report.results, result)
+}
+
+// Results returns all of the results of the commands that have completed
+func (report *Report) Results() []Result {
+	report.mut.Lock()
+	defer report.mut.Unlock()
+	return append([]Result{}, report.results...)
+}
+
+// Restarts counts how many times a service or task ran its cmds after the first time
+func (report *Report) Restarts(name string) int {
+	restarts := 0
+	for _, result := range report.Results() {
+		if result.Name == name && result.Run > restarts+1 {
+			restarts = result.Run - 1
+		}
+	}
+	return restarts
+}
+
+func (report *Report) print(w io.Writer) error {
+	results := report.Results()
+	if len(results) == 0 {
+		return nil
+	}
+	header := summaryRow{Name: "NAME", Step: "STEP", Cmd: "COMMAND", Code: "EXIT", Duration: "DURATION", Restarts: "RESTARTS"}
+	rows := []summaryRow{}
+	for _, result := range results {
+		code := fmt.Sprint(result.Code)
+		if result.Status == "stopped" {
+			code = "stopped"
+		}
+		rows = append(rows, summaryRow{
+			Name:     result.Name,
+			Step:     result.Step,
+			Cmd:      truncate(result.Cmd, maxSummaryCmdLen),
+			Code:     code,
+			Status:   result.Status,
+			Duration: result.Duration.Round(time.Millisecond).String(),
+			Restarts: fmt.Sprint(report.Restarts(result.Name)),
+		})
+	}
+	widths := summaryRow{}
+	for _, row := range append([]summaryRow{header}, rows...) {
+		widths.Name = longest(widths.Name, row.Name)
+		widths.Step = longest(widths.Step, row.Step)
+		widths.Cmd = longest(widths.Cmd, row.Cmd)
+		widths.Code = longest(widths.Code, row.Code)
+		widths.Duration = longest(widths.Duration, row.Duration)
+	}
+	pad := func(row summaryRow) summaryRow {
+		return summaryRow{
+			Name:     fmt.Sprintf("%-*v", len(widths.Name), row.Name),
+			Step:     fmt.Sprintf("%-*v", len(widths.Step), row.Step),
+			Cmd:      fmt.Sprintf("%-*v", len(widths.Cmd), row.Cmd),
+			Code:     fmt.Sprintf("%-*v", len(widths.Code), row.Code),
+			Status:   row.Status,
+			Duration: fmt.Sprintf("%-*v", len(widths.Duration), row.Duration),
+			Restarts: row.Restarts,
+		}
+	}
+	for i, row := range rows {
+		rows[i] = pad(row)
+	}
+	header = pad(header)
+	return term.NewScreenBuf(w).Render(summaryTemplate, map[string]any{
+		"Header": fmt.Sprintf("%v %v %v %v %v %v", header.Name, header.Step, header.Cmd, header.Code, header.Duration, header.Restarts),
+		"Rows":   rows,
+	})
+}
+
+func longest(a, b string) string {
+	if len(b) > len(a) {
+		return b
+	}
+	return a
+}
+
+func truncate(str string, max int) string {
+	runes := []rune(str)
+	if len(runes) <= max {
+		return str
+	}
+	return string(runes[:max-1]) + "…"
+}
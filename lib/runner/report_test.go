@@ -0,0 +1,25 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportRestarts(t *testing.T) {
+	report := &Report{}
+	report.add(Result{Name: "server", Step: "before"})
+	report.add(Result{Name: "server", Step: "cmds", Run: 1})
+	report.add(Result{Name: "client", Step: "cmds", Run: 1})
+	assert.Equal(t, 0, report.Restarts("server"))
+	report.add(Result{Name: "server", Step: "cmds", Run: 2})
+	report.add(Result{Name: "server", Step: "cmds", Run: 3})
+	assert.Equal(t, 2, report.Restarts("server"))
+	assert.Equal(t, 0, report.Restarts("client"))
+	assert.Len(t, report.Results(), 5)
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "go test", truncate("go test", 10))
+	assert.Equal(t, "go test ./…", truncate("go test ./... -v", 11))
+}
@@ -3,11 +3,13 @@ package runner
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"golang.org/x/exp/slices"
 
@@ -17,9 +19,31 @@ import (
 type (
 	// Config is configuration for the runner
 	Config struct {
-		Procfile *procfile.Procfile
-		Only     []string
-		Except   []string
+		Procfile   *procfile.Procfile
+		Only       []string
+		Except     []string
+		LogFormat  LogFormat
+		Timestamps bool
+		Attach     string
+		Trace      string
+		Yes        bool
+		// Stdin is the input of tasks and prompts, and is routed to the services
+		// by RunServices. It defaults to os.Stdin, and prompts and hotkeys are
+		// only used if it is a terminal.
+		Stdin io.Reader
+		// Stdout and Stderr receive the output of every service and task, they
+		// default to os.Stdout and os.Stderr. The summary, matrix grids, env
+		// violations, prompts, and stdin messages are output to Stderr.
+		Stdout io.Writer
+		Stderr io.Writer
+		// Events receives every line of output and lifecycle event as an Entry,
+		// whatever the LogFormat is. Sends block the process that wrote the
+		// entry, so the channel has to be read for as long as the runner is
+		// running. It is never closed by the runner.
+		Events chan<- Entry
+		// Executor runs the commands, along with the sh values of the env, it
+		// defaults to a NixExecutor
+		Executor Executor
 	}
 	// Runner coordinates between many processes
 	Runner struct {
@@ -29,15 +53,45 @@ type (
 		cancel   context.CancelFunc
 		sigc     chan os.Signal
 		titleLen int
+		mux      *Mux
+		attach   string
+		stdin    *stdinRouter
+		report   *Report
+		tracer   *Tracer
+		trace    string
+		yes      bool
+		needed   sync.Map
+		input    io.Reader
+		stdout   io.Writer
+		stderr   io.Writer
+		executor Executor
+		colorMut sync.Mutex
+		colors   int
 	}
 )
 
 // New creates a new runner for a parsed procfile
-func New(pfile *procfile.Procfile) *Runner {
+func New(cfg Config) *Runner {
 	ctx, cancel := context.WithCancel(context.Background())
 
+	if cfg.LogFormat == "" {
+		cfg.LogFormat = LogText
+	}
+	if cfg.Stdin == nil {
+		cfg.Stdin = os.Stdin
+	}
+	if cfg.Stdout == nil {
+		cfg.Stdout = os.Stdout
+	}
+	if cfg.Stderr == nil {
+		cfg.Stderr = os.Stderr
+	}
+	if cfg.Executor == nil {
+		cfg.Executor = NixExecutor{}
+	}
+
 	maxTitleLen := 0
-	for procName := range pfile.Services {
+	for procName := range cfg.Procfile.Services {
 		if maxTitleLen < len(procName) {
 			maxTitleLen = len(procName)
 		}
@@ -47,9 +101,22 @@ func New(pfile *procfile.Procfile) *Runner {
 		ctx:      ctx,
 		running:  map[int]*exec.Cmd{},
 		cancel:   cancel,
-		procfile: pfile,
+		procfile: cfg.Procfile,
 		titleLen: maxTitleLen,
 		sigc:     make(chan os.Signal, 1),
+		mux:      newMux(cfg.Stdout, cfg.Stderr, cfg.LogFormat, cfg.Timestamps),
+		attach:   cfg.Attach,
+		report:   &Report{},
+		trace:    cfg.Trace,
+		yes:      cfg.Yes,
+		input:    cfg.Stdin,
+		stdout:   cfg.Stdout,
+		stderr:   cfg.Stderr,
+		executor: cfg.Executor,
+	}
+	runner.mux.events = cfg.Events
+	if cfg.Trace != "" {
+		runner.tracer = newTracer()
 	}
 
 	signal.Notify(runner.sigc, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
@@ -61,20 +128,53 @@ func New(pfile *procfile.Procfile) *Runner {
 	return runner
 }
 
-// RunServices will start all of the default services
-func (runner *Runner) RunServices(names []string) error {
+// RunServices will start all of the default services. The state of the run is
+// written to StatePath while they are running, so that grind ps and the needs
+// of tasks in other grind commands can find them.
+func (runner *Runner) RunServices(names []string) (err error) {
 	procs := []*Process{}
+	procNames := []string{}
+	svcs := []*procfile.Service{}
 	for name, svc := range runner.procfile.Services {
 		if len(names) > 0 && !slices.Contains(names, name) {
 			continue
 		}
-		procs = append(procs, newProc(runner, svc))
+		procNames = append(procNames, name)
+		svcs = append(svcs, svc)
+	}
+	if err := runner.checkEnv(svcs...); err != nil {
+		return err
+	}
+	for _, svc := range svcs {
+		procs = append(procs, newProc(runner, svc, nil))
+	}
+	if runner.attach != "" && !slices.Contains(procNames, runner.attach) {
+		return fmt.Errorf("cannot attach to %v, it is not a running service", runner.attach)
 	}
+	stdin, err := newStdinRouter(runner.input, runner.stderr, procNames)
+	if err != nil {
+		return err
+	} else if err := stdin.listen(runner.attach); err != nil {
+		return err
+	}
+	defer stdin.close()
+	runner.stdin = stdin
+	state := &State{PID: os.Getpid(), Started: time.Now(), Services: procNames}
+	if err := state.write(runner.procfile); err != nil {
+		return err
+	}
+	defer state.remove(runner.procfile)
+	defer func() { err = runner.finish(err) }()
 	if err := runner.spawn(procs, func(proc *Process) error { return proc.before(true, nil) }); err != nil {
 		return err
 	}
 	defer runner.spawn(procs, func(proc *Process) error { return proc.after(true, nil) })
-	return runner.spawn(procs, func(proc *Process) error { return proc.cmd(true, nil) })
+	ctx, stopSchedule := context.WithCancel(runner.ctx)
+	wait := runner.schedule(ctx, state)
+	err = runner.spawn(procs, func(proc *Process) error { return proc.serve(proc.ctx) })
+	stopSchedule()
+	wait()
+	return err
 }
 
 func (runner *Runner) spawn(procs []*Process, fn func(*Process) error) error {
@@ -97,13 +197,82 @@ func (runner *Runner) spawn(procs []*Process, fn func(*Process) error) error {
 	return nil
 }
 
-// RunTask will start a single task
-func (runner *Runner) RunTask(name string, capture bool, args []string) error {
+// RunTask will start a single task, validating the args and flags passed to it
+// before anything is run.
+func (runner *Runner) RunTask(name string, capture bool, args []string, flags map[string]string) (err error) {
+	if capture {
+		defer func() { err = runner.finish(err) }()
+	}
+	return runner.runTask(runner.ctx, nil, nil, name, capture, args, flags)
+}
+
+// runTask runs a task, called by the parent process if there is one. The task
+// starts with the outputs of the caller, and passes back its own outputs into it.
+func (runner *Runner) runTask(ctx context.Context, parent *Process, outputs map[string]string, name string, capture bool, args []string, flags map[string]string) error {
 	task, ok := runner.procfile.Tasks[name]
 	if !ok {
 		return fmt.Errorf("undefined task %v", name)
 	}
-	return newProc(runner, task).run(true, args)
+	needs := []*procfile.Service{}
+	for _, name := range task.Needs {
+		needs = append(needs, runner.procfile.Services[name])
+	}
+	if err := runner.computeEnv(ctx, append([]*procfile.Service{task}, needs...)...); err != nil {
+		return err
+	}
+	checked := []*procfile.Service{task}
+	if len(task.Matrix) > 0 {
+		checked = task.Expand()
+	}
+	vars, err := task.ParseArgs(args, flags)
+	if err != nil {
+		return err
+	} else if err := runner.checkEnv(append(checked, needs...)...); err != nil {
+		return err
+	} else if err := runner.ask(task, vars); err != nil {
+		return err
+	}
+	stop, err := runner.startNeeds(task)
+	if err != nil {
+		return err
+	}
+	defer stop()
+	if len(task.Matrix) > 0 {
+		return runner.runMatrix(ctx, parent, outputs, task, capture, args, vars)
+	}
+	proc := newProc(runner, task, parent)
+	proc.ctx = ctx
+	proc.vars = vars
+	for key, val := range outputs {
+		proc.outputs[key] = val
+	}
+	err = proc.run(capture, args)
+	if outputs != nil {
+		for _, name := range task.Outputs {
+			if val, ok := proc.captures[name]; ok {
+				outputs[name] = val
+			}
+		}
+	}
+	return err
+}
+
+// Report returns the results of all the commands that have been run
+func (runner *Runner) Report() *Report {
+	return runner.report
+}
+
+// finish outputs the summary and writes the trace once everything has stopped
+func (runner *Runner) finish(err error) error {
+	if !runner.mux.format.structured() {
+		runner.report.print(runner.stderr)
+	}
+	if runner.tracer == nil {
+		return err
+	} else if traceErr := runner.tracer.Write(runner.trace); err == nil {
+		return traceErr
+	}
+	return err
 }
 
 // RunShell will start an interactive shell with deps
@@ -111,8 +280,10 @@ func (runner *Runner) RunShell(name string) error {
 	svc, ok := runner.procfile.Services[name]
 	if !ok {
 		return fmt.Errorf("undefined service %v", name)
+	} else if err := runner.checkEnv(svc); err != nil {
+		return err
 	}
-	return newProc(runner, svc).shell()
+	return newProc(runner, svc, nil).shell()
 }
 
 // RunCommand will run a command within the nix-shell
@@ -120,6 +291,8 @@ func (runner *Runner) RunCommand(name, cmd string) error {
 	svc, ok := runner.procfile.Services[name]
 	if !ok {
 		return fmt.Errorf("undefined service %v", name)
+	} else if err := runner.checkEnv(svc); err != nil {
+		return err
 	}
-	return newProc(runner, svc).exec(cmd)
+	return newProc(runner, svc, nil).exec(cmd)
 }
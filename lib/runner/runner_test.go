@@ -0,0 +1,209 @@
+package runner
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tanema/grind/lib/procfile"
+)
+
+func hostRunner(t *testing.T, config string, cfg Config) *Runner {
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "grind.yml"), []byte(config), 0o644))
+	pfile, err := procfile.Parse(filepath.Join(dir, "grind.yml"))
+	require.Nil(t, err)
+	cfg.Procfile = pfile
+	cfg.Executor = HostExecutor{}
+	return New(cfg)
+}
+
+// fakeNixShell puts a nix-shell on the PATH that runs the --command with sh
+// after running setup, which can fail like nix failing to evaluate.
+func fakeNixShell(t *testing.T, setup string) {
+	bin := t.TempDir()
+	script := "#!/bin/sh\n" + setup + "\nwhile [ $# -gt 0 ]; do\n  if [ \"$1\" = \"--command\" ]; then exec sh -c \"$2\"; fi\n  shift\ndone\n"
+	require.Nil(t, os.WriteFile(filepath.Join(bin, "nix-shell"), []byte(script), 0o755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestRunTaskWithHostExecutor(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	runner := hostRunner(t, `version: "1"
+tasks:
+  build:
+    env:
+      TARGET: linux
+    before: [echo "building for $TARGET"]
+    cmds:
+      - run: echo v1.2.3
+        capture: VERSION
+      - echo "built $VERSION in $(basename $(pwd))" >&2
+`, Config{Stdout: &stdout, Stderr: &stderr})
+	require.Nil(t, runner.RunTask("build", true, nil, nil))
+	assert.Contains(t, stdout.String(), "build | building for linux\n")
+	assert.Contains(t, stdout.String(), "build | v1.2.3\n")
+	assert.Contains(t, stderr.String(), "build | built v1.2.3 in "+filepath.Base(runner.procfile.Dir)+"\n")
+	assert.Contains(t, stderr.String(), "Summary:")
+	assert.NotContains(t, stdout.String(), "Summary:")
+	results := runner.Report().Results()
+	require.Len(t, results, 3)
+	assert.Equal(t, "before", results[0].Step)
+	assert.Equal(t, "ok", results[2].Status)
+}
+
+func TestComputedEnvWithHostExecutor(t *testing.T) {
+	t.Setenv("PATH", "/bin:/usr/bin")
+	var stdout bytes.Buffer
+	runner := hostRunner(t, `version: "1"
+env:
+  USER_NAME: {sh: echo grinder}
+tasks:
+  greet:
+    cmds: [echo "hi $USER_NAME"]
+`, Config{Stdout: &stdout, Stderr: &stdout})
+	require.Nil(t, runner.RunTask("greet", true, nil, nil))
+	assert.Contains(t, stdout.String(), "greet | hi grinder\n")
+}
+
+func TestRunnerEvents(t *testing.T) {
+	events := make(chan Entry)
+	collected := []Entry{}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for entry := range events {
+			collected = append(collected, entry)
+		}
+	}()
+	var stdout bytes.Buffer
+	runner := hostRunner(t, `version: "1"
+tasks:
+  check:
+    cmds:
+      - echo checking
+      - exit 3
+`, Config{Stdout: &stdout, Stderr: &stdout, Events: events})
+	err := runner.RunTask("check", true, nil, nil)
+	close(events)
+	wg.Wait()
+	assert.EqualError(t, err, "exit status 3")
+
+	kinds := []string{}
+	for _, entry := range collected {
+		assert.Equal(t, "check", entry.Name)
+		assert.Equal(t, "task", entry.Kind)
+		assert.False(t, entry.Time.IsZero())
+		if entry.Stream != "" {
+			kinds = append(kinds, entry.Stream+": "+entry.Line)
+		} else if entry.Event != "ready" {
+			kinds = append(kinds, entry.Event+": "+entry.Cmd)
+		}
+	}
+	assert.Equal(t, []string{
+		"start: echo checking",
+		"stdout: checking",
+		"exit: echo checking",
+		"start: exit 3",
+		"exit: exit 3",
+		"step: ",
+	}, kinds)
+	assert.Equal(t, 3, *collected[len(collected)-2].Code)
+	assert.Contains(t, stdout.String(), "check | checking\n", "events are sent along with the text output")
+}
+
+func TestRunServicesWithHostExecutor(t *testing.T) {
+	var stdout bytes.Buffer
+	runner := hostRunner(t, `version: "1"
+services:
+  web:
+    env:
+      PORT: "8080"
+    before: [echo "before $SVC"]
+    cmds: [echo "serving on $PORT"]
+    after: [echo "after $SVC"]
+`, Config{Stdout: &stdout, Stderr: &stdout, LogFormat: LogFmt})
+	require.Nil(t, runner.RunServices(nil))
+	out := stdout.String()
+	before := bytes.Index(stdout.Bytes(), []byte("line=\"before web\""))
+	serving := bytes.Index(stdout.Bytes(), []byte("line=\"serving on 8080\""))
+	after := bytes.Index(stdout.Bytes(), []byte("line=\"after web\""))
+	assert.True(t, before >= 0 && serving > before && after > serving, out)
+	_, err := os.Stat(StatePath(runner.procfile))
+	assert.True(t, os.IsNotExist(err), "the state is removed once the services stop")
+}
+
+func TestRunnerStdin(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	runner := hostRunner(t, `version: "1"
+tasks:
+  greet:
+    cmds: [read name && echo "hi $$name"]
+`, Config{Stdin: strings.NewReader("grinder\n"), Stdout: &stdout, Stderr: &stderr})
+	require.Nil(t, runner.RunTask("greet", true, nil, nil))
+	assert.Contains(t, stdout.String(), "greet | hi grinder\n")
+}
+
+func TestStructuredRunnerKeepsColor(t *testing.T) {
+	noColor := color.NoColor
+	t.Cleanup(func() { color.NoColor = noColor })
+	color.NoColor = false
+	hostRunner(t, "version: \"1\"\n", Config{LogFormat: LogJSON})
+	assert.False(t, color.NoColor, "other runners in the process keep their colors")
+}
+
+func TestNeedsWaitsForStart(t *testing.T) {
+	events := make(chan Entry, 100)
+	var stdout bytes.Buffer
+	runner := hostRunner(t, `version: "1"
+services:
+  db:
+    cmds:
+      - run: sleep 30
+        if: sleep 0.2
+tasks:
+  migrate:
+    needs: [db]
+    cmds: [echo migrating]
+`, Config{Stdout: &stdout, Stderr: &stdout, Events: events})
+	require.Nil(t, runner.RunTask("migrate", true, nil, nil))
+	close(events)
+	started := []string{}
+	for entry := range events {
+		if entry.Event == "start" {
+			started = append(started, entry.Name)
+		}
+	}
+	assert.Equal(t, []string{"db", "migrate"}, started)
+}
+
+func TestNeedsSkipsAfterWhenBeforeFails(t *testing.T) {
+	var stdout bytes.Buffer
+	runner := hostRunner(t, `version: "1"
+services:
+  cache:
+    before: [echo "before cache"]
+    cmds: [sleep 30]
+    after: [echo "after cache"]
+  db:
+    before: [exit 3]
+    cmds: [sleep 30]
+    after: [echo "after db"]
+tasks:
+  migrate:
+    needs: [cache, db]
+    cmds: [echo migrating]
+`, Config{Stdout: &stdout, Stderr: &stdout})
+	require.NotNil(t, runner.RunTask("migrate", true, nil, nil))
+	assert.Contains(t, stdout.String(), "after cache\n", "services that started are still cleaned up")
+	assert.NotContains(t, stdout.String(), "after db")
+	assert.NotContains(t, stdout.String(), "migrating")
+}
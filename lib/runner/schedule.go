@@ -0,0 +1,66 @@
+package runner
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+
+	"github.com/tanema/grind/lib/procfile"
+)
+
+// schedule runs every task with a schedule until the context is done. A task is
+// never run while its previous run is still going, the run is skipped instead.
+// The returned func waits for any runs that are still in progress.
+func (runner *Runner) schedule(ctx context.Context, state *State) func() {
+	var wg sync.WaitGroup
+	for _, task := range runner.procfile.Tasks {
+		if task.Schedule == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(task *procfile.Service) {
+			defer wg.Done()
+			runner.scheduleTask(ctx, state, task)
+		}(task)
+	}
+	return wg.Wait
+}
+
+func (runner *Runner) scheduleTask(ctx context.Context, state *State, task *procfile.Service) {
+	proc := newProc(runner, task, nil)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	running := false
+	var mut sync.Mutex
+	for {
+		next := task.Schedule.Next(time.Now())
+		if err := state.scheduled(runner.procfile, task.Name, next); err != nil {
+			proc.log.event(Entry{Event: "schedule", Error: err.Error()}, color.RedString("🔥 %v", err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		mut.Lock()
+		if running {
+			mut.Unlock()
+			proc.log.skip(task.Schedule.String(), "the previous run is still running")
+			continue
+		}
+		running = true
+		mut.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := runner.runTask(runner.ctx, proc, nil, task.Name, true, nil, nil); err != nil {
+				proc.log.event(Entry{Event: "schedule", Error: err.Error()}, color.RedString("🔥 %v", err))
+			}
+			mut.Lock()
+			running = false
+			mut.Unlock()
+		}()
+	}
+}
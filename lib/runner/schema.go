@@ -0,0 +1,83 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tanema/grind/lib/procfile"
+	"github.com/tanema/grind/lib/term"
+)
+
+const envViolationsTemplate = `{{"Invalid env:" | bold | bright}} {{.Names | bold}}
+  {{.Header | faint}}
+{{- range .Rows}}
+  {{.Service | bold}} {{.Name | bold}} {{.Problem | red}}{{if .Description}} {{.Description | faint}}{{end}}
+{{- end}}`
+
+type envViolationRow struct {
+	Service string
+	procfile.EnvViolation
+}
+
+// checkEnv computes and validates the env of every service against the
+// env_schema before any of them are started, printing a table of everything
+// that is wrong.
+func (runner *Runner) checkEnv(svcs ...*procfile.Service) error {
+	if err := runner.computeEnv(runner.ctx, svcs...); err != nil {
+		return err
+	}
+	rows, names := []envViolationRow{}, []string{}
+	svcs = append([]*procfile.Service{}, svcs...)
+	sort.Slice(svcs, func(i, j int) bool { return svcs[i].Name < svcs[j].Name })
+	for _, svc := range svcs {
+		violations := svc.CheckEnv()
+		if len(violations) > 0 {
+			names = append(names, svc.Name)
+		}
+		for _, violation := range violations {
+			rows = append(rows, envViolationRow{Service: svc.Name, EnvViolation: violation})
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	header := envViolationRow{Service: "SERVICE", EnvViolation: procfile.EnvViolation{Name: "NAME", Problem: "PROBLEM"}}
+	svcWidth, nameWidth := len(header.Service), len(header.Name)
+	for _, row := range rows {
+		if len(row.Service) > svcWidth {
+			svcWidth = len(row.Service)
+		}
+		if len(row.Name) > nameWidth {
+			nameWidth = len(row.Name)
+		}
+	}
+	for i, row := range append([]envViolationRow{header}, rows...) {
+		row.Service = fmt.Sprintf("%-*v", svcWidth, row.Service)
+		row.Name = fmt.Sprintf("%-*v", nameWidth, row.Name)
+		if i == 0 {
+			header = row
+		} else {
+			rows[i-1] = row
+		}
+	}
+	term.NewScreenBuf(runner.stderr).Render(envViolationsTemplate, map[string]any{
+		"Names":  strings.Join(names, ", "),
+		"Header": fmt.Sprintf("%v %v %v", header.Service, header.Name, header.Problem),
+		"Rows":   rows,
+	})
+	return fmt.Errorf("the env of %v does not match the env_schema", strings.Join(names, ", "))
+}
+
+// computeEnv computes the env values of the services that come from sh
+// commands or files, which is put off until they are run. Commands are run with
+// the executor of the runner, and stopped with the ctx.
+func (runner *Runner) computeEnv(ctx context.Context, svcs ...*procfile.Service) error {
+	for _, svc := range svcs {
+		if err := svc.ComputeEnv(ctx, runner.executor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/tanema/grind/lib/procfile"
+	"github.com/tanema/grind/lib/term"
+)
+
+// psTimeFormat includes the day since scheduled tasks may not run until tomorrow
+const psTimeFormat = "Mon 15:04:05"
+
+const psTemplate = `{{"grind run" | bold | bright}} {{print "pid " .PID ", started " .Started | faint}}
+  {{.Header | faint}}
+{{- range .Rows}}
+  {{.Name | bold}} {{.Kind | faint}} {{.Status}}
+{{- end}}`
+
+// State is written by grind run while it is running so that other grind
+// commands in the same project can find the services that are already up.
+type State struct {
+	mut       sync.Mutex
+	PID       int                  `json:"pid"`
+	Started   time.Time            `json:"started"`
+	Services  []string             `json:"services"`
+	Schedules map[string]time.Time `json:"schedules,omitempty"`
+}
+
+// StatePath is where the state of grind run is kept for a project
+func StatePath(pfile *procfile.Procfile) string {
+	return filepath.Join(pfile.Dir, ".grind", "run.json")
+}
+
+// ReadState returns the state of grind run for a project, it returns nil if
+// grind run is not running.
+func ReadState(pfile *procfile.Procfile) (*State, error) {
+	data, err := os.ReadFile(StatePath(pfile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	state := &State{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	} else if syscall.Kill(state.PID, 0) != nil {
+		// the process that wrote the state has died without cleaning up
+		return nil, nil
+	}
+	return state, nil
+}
+
+// Running checks if grind run has a service running
+func (state *State) Running(name string) bool {
+	return state != nil && slices.Contains(state.Services, name)
+}
+
+// scheduled records the next time a scheduled task will run
+func (state *State) scheduled(pfile *procfile.Procfile, name string, next time.Time) error {
+	state.mut.Lock()
+	if state.Schedules == nil {
+		state.Schedules = map[string]time.Time{}
+	}
+	state.Schedules[name] = next
+	state.mut.Unlock()
+	return state.write(pfile)
+}
+
+// Print outputs the running services and the next run time of every scheduled task
+func (state *State) Print() error {
+	type psRow struct{ Name, Kind, Status string }
+	state.mut.Lock()
+	rows := []psRow{}
+	for _, name := range state.Services {
+		rows = append(rows, psRow{Name: name, Kind: "service", Status: "running"})
+	}
+	names := []string{}
+	for name := range state.Schedules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		next := state.Schedules[name]
+		status := fmt.Sprintf("next run at %v (in %v)", next.Format(psTimeFormat), time.Until(next).Round(time.Second))
+		rows = append(rows, psRow{Name: name, Kind: "task", Status: status})
+	}
+	state.mut.Unlock()
+	header := psRow{Name: "NAME", Kind: "KIND", Status: "STATUS"}
+	nameWidth, kindWidth := len(header.Name), len("service")
+	for _, row := range rows {
+		if len(row.Name) > nameWidth {
+			nameWidth = len(row.Name)
+		}
+	}
+	for i, row := range append([]psRow{header}, rows...) {
+		row.Name = fmt.Sprintf("%-*v", nameWidth, row.Name)
+		row.Kind = fmt.Sprintf("%-*v", kindWidth, row.Kind)
+		if i == 0 {
+			header = row
+		} else {
+			rows[i-1] = row
+		}
+	}
+	return term.Println(psTemplate, map[string]any{
+		"PID":     state.PID,
+		"Started": state.Started.Format(psTimeFormat),
+		"Header":  fmt.Sprintf("%v %v %v", header.Name, header.Kind, header.Status),
+		"Rows":    rows,
+	})
+}
+
+func (state *State) write(pfile *procfile.Procfile) error {
+	state.mut.Lock()
+	defer state.mut.Unlock()
+	path := StatePath(pfile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func (state *State) remove(pfile *procfile.Procfile) {
+	if current, _ := ReadState(pfile); current != nil && current.PID == state.PID {
+		os.Remove(StatePath(pfile))
+	}
+}
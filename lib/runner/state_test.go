@@ -0,0 +1,39 @@
+package runner
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tanema/grind/lib/procfile"
+)
+
+func TestState(t *testing.T) {
+	pfile := &procfile.Procfile{Dir: t.TempDir()}
+	state, err := ReadState(pfile)
+	assert.Nil(t, err)
+	assert.Nil(t, state)
+	assert.False(t, state.Running("db"))
+
+	running := &State{PID: os.Getpid(), Services: []string{"db"}}
+	assert.Nil(t, running.write(pfile))
+	state, err = ReadState(pfile)
+	assert.Nil(t, err)
+	assert.True(t, state.Running("db"))
+	assert.False(t, state.Running("server"))
+
+	(&State{PID: -1}).remove(pfile)
+	assert.FileExists(t, StatePath(pfile))
+	running.remove(pfile)
+	assert.NoFileExists(t, StatePath(pfile))
+}
+
+func TestStaleState(t *testing.T) {
+	pfile := &procfile.Procfile{Dir: t.TempDir()}
+	// pids are never this high so the process can't be running
+	assert.Nil(t, (&State{PID: 1 << 30, Services: []string{"db"}}).write(pfile))
+	state, err := ReadState(pfile)
+	assert.Nil(t, err)
+	assert.Nil(t, state)
+}
@@ -0,0 +1,187 @@
+//go:build !windows
+// +build !windows
+
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tanema/grind/lib/term"
+)
+
+// detachKey is ctrl-] which will detach the terminal from a service
+const detachKey = 0x1d
+
+// stdinRouter owns the terminal input while services are running so that they
+// do not compete for it. By default input goes nowhere, pressing the number of
+// a service attaches the terminal to its stdin until the detach key is pressed.
+// If stdin is not a terminal there are no hotkeys and input only ever goes to
+// the service that was attached at startup.
+type stdinRouter struct {
+	mut      sync.Mutex
+	in       io.Reader
+	out      io.Writer
+	done     chan struct{}
+	term     *term.Terminal
+	names    []string
+	targets  map[string]*stdinTarget
+	attached string
+}
+
+// stdinTarget is the stdin of the currently running command of a process
+type stdinTarget struct {
+	writer io.Writer
+	tty    bool
+}
+
+// newStdinRouter routes in to the services, writing messages about where it is
+// attached to out.
+func newStdinRouter(in io.Reader, out io.Writer, names []string) (*stdinRouter, error) {
+	router := &stdinRouter{
+		in:      in,
+		out:     out,
+		done:    make(chan struct{}),
+		names:   names,
+		targets: map[string]*stdinTarget{},
+	}
+	sort.Strings(router.names)
+	if file, ok := in.(*os.File); ok && term.IsTerminal(int(file.Fd())) {
+		terminal, err := term.NewTerminal(int(file.Fd()))
+		if err != nil {
+			return nil, err
+		}
+		router.term = terminal
+	}
+	return router, nil
+}
+
+func (r *stdinRouter) listen(attach string) error {
+	if r.term == nil {
+		r.attached = attach
+	} else if err := r.term.Cbreak(); err != nil {
+		return err
+	} else if attach != "" {
+		r.attach(attach)
+	} else {
+		r.help()
+	}
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := readInput(r.in, buf, r.done)
+			if err != nil {
+				return
+			}
+			r.handle(buf[:n])
+		}
+	}()
+	return nil
+}
+
+func (r *stdinRouter) close() error {
+	close(r.done)
+	if r.term == nil {
+		return nil
+	}
+	return r.term.Restore()
+}
+
+// register sets the stdin of a command that has just started for a process
+func (r *stdinRouter) register(name string, writer io.Writer, tty bool) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.targets[name] = &stdinTarget{writer: writer, tty: tty}
+	if r.attached == name {
+		r.setMode()
+	}
+}
+
+// unregister removes the stdin of a command once it has exited
+func (r *stdinRouter) unregister(name string) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	delete(r.targets, name)
+}
+
+func (r *stdinRouter) handle(input []byte) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if r.attached == "" && r.term == nil {
+		return
+	} else if r.attached == "" {
+		for _, key := range input {
+			if i := int(key - '1'); i >= 0 && i < len(r.names) {
+				r.attachLocked(r.names[i])
+				return
+			}
+		}
+		r.helpLocked()
+		return
+	}
+	target := r.targets[r.attached]
+	if i := bytes.IndexByte(input, detachKey); i >= 0 {
+		// a partial line in cooked mode was not meant to be sent
+		if target != nil && target.tty {
+			target.writer.Write(input[:i])
+		}
+		r.detachLocked()
+		return
+	}
+	if target != nil {
+		target.writer.Write(input)
+	}
+}
+
+func (r *stdinRouter) attach(name string) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.attachLocked(name)
+}
+
+func (r *stdinRouter) attachLocked(name string) {
+	r.attached = name
+	r.setMode()
+	term.NewScreenBuf(r.out).Render(`{{"stdin attached to" | faint}} {{.name | bold | cyan}}{{", press ctrl-] to detach" | faint}}`, map[string]string{"name": name})
+}
+
+func (r *stdinRouter) detachLocked() {
+	r.attached = ""
+	r.setMode()
+	term.NewScreenBuf(r.out).Render(`{{"stdin detached" | faint}}`, nil)
+}
+
+// setMode puts the terminal into a mode suitable for the attached process. A
+// pty handles its own echo and line editing so every key is passed through raw,
+// a plain pipe gets a line at a time with the detach key also ending the line.
+func (r *stdinRouter) setMode() {
+	target := r.targets[r.attached]
+	if r.term == nil {
+		return
+	} else if r.attached == "" {
+		r.term.Cbreak()
+	} else if target != nil && target.tty {
+		r.term.Raw()
+	} else {
+		r.term.Cooked(detachKey)
+	}
+}
+
+func (r *stdinRouter) help() {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.helpLocked()
+}
+
+func (r *stdinRouter) helpLocked() {
+	keys := []string{}
+	for i, name := range r.names {
+		keys = append(keys, fmt.Sprintf("[%v] %v", i+1, name))
+	}
+	term.NewScreenBuf(r.out).Render(`{{"press a number to attach stdin:" | faint}} {{. | bold}}`, strings.Join(keys, " "))
+}
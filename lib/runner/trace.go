@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+type (
+	// Tracer records spans for every step, command, and nix environment evaluation
+	// so that they can be written out in the chrome trace event format and
+	// inspected with chrome://tracing or https://ui.perfetto.dev
+	Tracer struct {
+		mut    sync.Mutex
+		start  time.Time
+		events []traceEvent
+		lanes  map[string]int
+	}
+	// span is a single in progress trace event
+	span struct {
+		tracer *Tracer
+		event  traceEvent
+		start  time.Time
+	}
+	traceEvent struct {
+		Name string         `json:"name"`
+		Cat  string         `json:"cat,omitempty"`
+		Ph   string         `json:"ph"`
+		Ts   int64          `json:"ts"`
+		Dur  int64          `json:"dur,omitempty"`
+		Pid  int            `json:"pid"`
+		Tid  int            `json:"tid"`
+		Args map[string]any `json:"args,omitempty"`
+	}
+	traceFile struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}
+)
+
+func newTracer() *Tracer {
+	return &Tracer{start: time.Now(), lanes: map[string]int{}}
+}
+
+// lane returns the process lane for a top level service or task, every nested
+// task that it calls will be drawn within this lane.
+func (tracer *Tracer) lane(name string) int {
+	if tracer == nil {
+		return 0
+	}
+	tracer.mut.Lock()
+	defer tracer.mut.Unlock()
+	if pid, ok := tracer.lanes[name]; ok {
+		return pid
+	}
+	pid := len(tracer.lanes) + 1
+	tracer.lanes[name] = pid
+	tracer.events = append(tracer.events, traceEvent{
+		Name: "process_name",
+		Ph:   "M",
+		Pid:  pid,
+		Args: map[string]any{"name": name},
+	})
+	return pid
+}
+
+// span starts a new span in a lane, it will be recorded once it is ended.
+func (tracer *Tracer) span(pid int, cat, name string, args map[string]any) *span {
+	if tracer == nil {
+		return nil
+	}
+	return &span{
+		tracer: tracer,
+		start:  time.Now(),
+		event:  traceEvent{Name: name, Cat: cat, Ph: "X", Pid: pid, Tid: 1, Args: args},
+	}
+}
+
+func (s *span) end() {
+	if s == nil {
+		return
+	}
+	s.event.Ts = s.start.Sub(s.tracer.start).Microseconds()
+	s.event.Dur = time.Since(s.start).Microseconds()
+	s.tracer.mut.Lock()
+	defer s.tracer.mut.Unlock()
+	s.tracer.events = append(s.tracer.events, s.event)
+}
+
+// Write will output all of the recorded spans to a file as json
+func (tracer *Tracer) Write(path string) error {
+	tracer.mut.Lock()
+	defer tracer.mut.Unlock()
+	data, err := json.MarshalIndent(traceFile{
+		TraceEvents:     tracer.events,
+		DisplayTimeUnit: "ms",
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
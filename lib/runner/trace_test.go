@@ -0,0 +1,90 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracerWrite(t *testing.T) {
+	tracer := newTracer()
+	assert.Equal(t, 1, tracer.lane("server"))
+	assert.Equal(t, 2, tracer.lane("client"))
+	assert.Equal(t, 1, tracer.lane("server"))
+
+	parent := tracer.span(1, "step", "cmds", nil)
+	child := tracer.span(1, "cmd", "go run main.go", map[string]any{"name": "server"})
+	child.end()
+	parent.end()
+
+	path := filepath.Join(t.TempDir(), "trace.json")
+	assert.Nil(t, tracer.Write(path))
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+
+	var trace traceFile
+	assert.Nil(t, json.Unmarshal(data, &trace))
+	assert.Len(t, trace.TraceEvents, 4)
+	assert.Equal(t, "process_name", trace.TraceEvents[0].Name)
+	assert.Equal(t, "M", trace.TraceEvents[0].Ph)
+	assert.Equal(t, "go run main.go", trace.TraceEvents[2].Name)
+	assert.Equal(t, "cmds", trace.TraceEvents[3].Name)
+	assert.LessOrEqual(t, trace.TraceEvents[3].Ts, trace.TraceEvents[2].Ts)
+	assert.GreaterOrEqual(t, trace.TraceEvents[3].Dur, trace.TraceEvents[2].Dur)
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	assert.Equal(t, 0, tracer.lane("server"))
+	tracer.span(0, "cmd", "echo", nil).end()
+}
+
+func TestTraceRedactsSecrets(t *testing.T) {
+	var stdout bytes.Buffer
+	path := filepath.Join(t.TempDir(), "trace.json")
+	runner := hostRunner(t, `version: "1"
+tasks:
+  deploy:
+    env:
+      TOKEN: secret:abc123
+    args:
+      - name: token
+    cmds: [test abc123 = "$1"]
+`, Config{Stdout: &stdout, Stderr: &stdout, Trace: path})
+	require.Nil(t, runner.RunTask("deploy", true, []string{"abc123"}, nil))
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	assert.NotContains(t, string(data), "abc123")
+	assert.Contains(t, string(data), `test ****** = \"$1\"`)
+}
+
+func TestTraceNixStartup(t *testing.T) {
+	fakeNixShell(t, "sleep 0.1")
+
+	var stdout bytes.Buffer
+	path := filepath.Join(t.TempDir(), "trace.json")
+	runner := hostRunner(t, `version: "1"
+tasks:
+  quote:
+    cmds: [echo 'it'"'"'s quoted']
+`, Config{Stdout: &stdout, Stderr: &stdout, Trace: path})
+	runner.executor = NixExecutor{}
+	require.Nil(t, runner.RunTask("quote", true, nil, nil))
+	assert.Contains(t, stdout.String(), "quote | it's quoted\n")
+
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	var trace traceFile
+	require.Nil(t, json.Unmarshal(data, &trace))
+	spans := map[string]int64{}
+	for _, event := range trace.TraceEvents {
+		spans[event.Name] = event.Dur
+	}
+	assert.Contains(t, spans, `echo 'it'"'"'s quoted'`)
+	assert.GreaterOrEqual(t, spans["nix-shell"], int64(100000))
+}
@@ -0,0 +1,68 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// watchInterval is how often the templates of a running service are checked
+var watchInterval = 500 * time.Millisecond
+
+// serve runs the cmds of a service until the context is done. While they run,
+// the templates of the service are watched, and if one changes the cmds are
+// stopped, the templates rendered again, and the cmds restarted.
+func (proc *Process) serve(ctx context.Context) error {
+	if len(proc.defn.Templates) == 0 {
+		return proc.cmd(ctx, true, nil)
+	}
+	for {
+		runCtx, cancel := context.WithCancel(ctx)
+		changes := make(chan string, 1)
+		go watchFiles(runCtx, proc.defn.TemplateFiles(), func(file string) {
+			changes <- file
+			cancel()
+		})
+		err := proc.cmd(runCtx, true, nil)
+		cancel()
+		select {
+		case file := <-changes:
+			if ctx.Err() != nil {
+				return err
+			}
+			proc.log.restart(file)
+			if err := proc.render(true); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+	}
+}
+
+// watchFiles polls files until the context is done, calling changed with the
+// first file that is modified.
+func watchFiles(ctx context.Context, files []string, changed func(file string)) {
+	stats := map[string]os.FileInfo{}
+	for _, file := range files {
+		stats[file], _ = os.Stat(file)
+	}
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, file := range files {
+			info, err := os.Stat(file)
+			if err != nil {
+				continue
+			} else if prev := stats[file]; prev == nil || !info.ModTime().Equal(prev.ModTime()) || info.Size() != prev.Size() {
+				changed(file)
+				return
+			}
+		}
+	}
+}
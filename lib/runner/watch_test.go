@@ -0,0 +1,69 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchFiles(t *testing.T) {
+	interval := watchInterval
+	watchInterval = 10 * time.Millisecond
+	t.Cleanup(func() { watchInterval = interval })
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "nginx.conf.tmpl")
+	require.Nil(t, os.WriteFile(file, []byte("listen 80;"), 0o644))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan string, 1)
+	go watchFiles(ctx, []string{file, filepath.Join(dir, "missing")}, func(file string) { changed <- file })
+
+	time.Sleep(30 * time.Millisecond)
+	select {
+	case <-changed:
+		t.Fatal("reported a change before the file was changed")
+	default:
+	}
+	require.Nil(t, os.WriteFile(file, []byte("listen 8080;"), 0o644))
+	select {
+	case got := <-changed:
+		assert.Equal(t, file, got)
+	case <-time.After(time.Second):
+		t.Fatal("did not report the change")
+	}
+}
+
+func TestNeededServiceWithTemplatesStops(t *testing.T) {
+	var stdout bytes.Buffer
+	runner := hostRunner(t, `version: "1"
+services:
+  db:
+    env:
+      PORT: "5432"
+    templates:
+      - src: db.conf.tmpl
+        dest: db.conf
+    cmds: [sleep 30]
+tasks:
+  migrate:
+    needs: [db]
+    cmds: [cat db.conf]
+`, Config{Stdout: &stdout, Stderr: &stdout})
+	require.Nil(t, os.WriteFile(filepath.Join(runner.procfile.Dir, "db.conf.tmpl"), []byte("port {{.Port}}\n"), 0o644))
+	done := make(chan error, 1)
+	go func() { done <- runner.RunTask("migrate", true, nil, nil) }()
+	select {
+	case err := <-done:
+		require.Nil(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the needed service did not stop when the task finished")
+	}
+	assert.Contains(t, stdout.String(), "migrate | port 5432\n")
+}
@@ -0,0 +1,84 @@
+package secrets
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mask is what secret values are replaced with in output
+const Mask = "******"
+
+// minSecretLen is the shortest value that is redacted, masking every 1 or true
+// in the output would make it unreadable while hiding nothing.
+const minSecretLen = 4
+
+// Redactor replaces secret values in output with the Mask. It is safe to use
+// from many goroutines.
+type Redactor struct {
+	mut      sync.RWMutex
+	values   map[string]bool
+	replacer *strings.Replacer
+}
+
+// Add registers more values to be redacted
+func (r *Redactor) Add(values ...string) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if r.values == nil {
+		r.values = map[string]bool{}
+	}
+	for _, val := range values {
+		if len(val) >= minSecretLen {
+			r.values[val] = true
+		}
+	}
+	sorted := []string{}
+	for val := range r.values {
+		sorted = append(sorted, val)
+	}
+	// replace longer values first so a secret that contains another is fully masked
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+	pairs := []string{}
+	for _, val := range sorted {
+		pairs = append(pairs, val, Mask)
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+// Redact masks every registered secret in str
+func (r *Redactor) Redact(str string) string {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	if r.replacer == nil {
+		return str
+	}
+	return r.replacer.Replace(str)
+}
+
+// Partial returns the length of the longest end of str that could be the start
+// of a secret, so that output written in parts can hold it back until the rest
+// of the secret arrives, rather than writing out the two halves unmasked.
+func (r *Redactor) Partial(str string) int {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	longest := 0
+	for val := range r.values {
+		n := len(val) - 1
+		if len(str) < n {
+			n = len(str)
+		}
+		for ; n > longest; n-- {
+			if strings.HasSuffix(str, val[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
@@ -0,0 +1,25 @@
+package secrets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactor(t *testing.T) {
+	var r Redactor
+	assert.Equal(t, "token abc123", r.Redact("token abc123"))
+	r.Add("abc123", "abc123456", "1", "")
+	assert.Equal(t, "token ****** and ****** 1", r.Redact("token abc123 and abc123456 1"))
+}
+
+func TestRedactorPartial(t *testing.T) {
+	var r Redactor
+	assert.Equal(t, 0, r.Partial("token hun"))
+	r.Add("hunter22", "abc123")
+	assert.Equal(t, 3, r.Partial("token hun"))
+	assert.Equal(t, 5, r.Partial("token abc12"))
+	assert.Equal(t, 0, r.Partial("token hunter22"))
+	assert.Equal(t, 0, r.Partial("token"))
+	assert.Equal(t, 2, r.Partial("hu"))
+}
@@ -0,0 +1,162 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+const (
+	// PassphraseEnv is the env var for a passphrase to encrypt secrets with
+	// instead of an identity file.
+	PassphraseEnv = "GRIND_SECRETS_PASSPHRASE"
+	// IdentityEnv is the env var for the path to an age identity file, it
+	// defaults to .grind/key.txt in the project.
+	IdentityEnv = "GRIND_SECRETS_IDENTITY"
+)
+
+// binaryHeader is the first line of a file encrypted by age without armor
+const binaryHeader = "age-encryption.org/v1\n"
+
+// Keys finds the keys to encrypt and decrypt env files for a project. Files are
+// encrypted in the age format so that they can also be managed with the age
+// cli. A passphrase in GRIND_SECRETS_PASSPHRASE takes precedence, otherwise
+// the X25519 identities in the identity file are used.
+type Keys struct {
+	Dir        string
+	once       sync.Once
+	identities []age.Identity
+	err        error
+}
+
+// IsEncrypted checks if data is an age encrypted file, armored or not
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(binaryHeader)) ||
+		bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte(armor.Header))
+}
+
+// IdentityPath is the file that identities are read from
+func (keys *Keys) IdentityPath() string {
+	if path := os.Getenv(IdentityEnv); path != "" {
+		return path
+	}
+	return filepath.Join(keys.Dir, ".grind", "key.txt")
+}
+
+// ReadFile reads a file, decrypting it if it is encrypted
+func (keys *Keys) ReadFile(path string) ([]byte, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil || !IsEncrypted(data) {
+		return data, false, err
+	}
+	plain, err := keys.Decrypt(data)
+	if err != nil {
+		return nil, true, fmt.Errorf("%v: %v", path, err)
+	}
+	return plain, true, nil
+}
+
+// Decrypt decrypts an age encrypted file
+func (keys *Keys) Decrypt(data []byte) ([]byte, error) {
+	identities, err := keys.loadIdentities()
+	if err != nil {
+		return nil, err
+	}
+	var src io.Reader = bytes.NewReader(data)
+	if !bytes.HasPrefix(data, []byte(binaryHeader)) {
+		src = armor.NewReader(src)
+	}
+	r, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// Encrypt encrypts data into an armored age file. If there is no passphrase or
+// identity file yet, a new identity is created.
+func (keys *Keys) Encrypt(data []byte) ([]byte, error) {
+	recipients, err := keys.recipients()
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	armored := armor.NewWriter(&out)
+	w, err := age.Encrypt(armored, recipients...)
+	if err != nil {
+		return nil, err
+	} else if _, err := w.Write(data); err != nil {
+		return nil, err
+	} else if err := w.Close(); err != nil {
+		return nil, err
+	} else if err := armored.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func (keys *Keys) loadIdentities() ([]age.Identity, error) {
+	keys.once.Do(func() {
+		if pass := os.Getenv(PassphraseEnv); pass != "" {
+			identity, err := age.NewScryptIdentity(pass)
+			keys.identities, keys.err = []age.Identity{identity}, err
+			return
+		}
+		f, err := os.Open(keys.IdentityPath())
+		if errors.Is(err, os.ErrNotExist) {
+			keys.err = fmt.Errorf("no key to decrypt secrets, set %v or add an age identity to %v", PassphraseEnv, keys.IdentityPath())
+			return
+		} else if err != nil {
+			keys.err = err
+			return
+		}
+		defer f.Close()
+		keys.identities, keys.err = age.ParseIdentities(f)
+	})
+	return keys.identities, keys.err
+}
+
+func (keys *Keys) recipients() ([]age.Recipient, error) {
+	if pass := os.Getenv(PassphraseEnv); pass != "" {
+		recipient, err := age.NewScryptRecipient(pass)
+		return []age.Recipient{recipient}, err
+	} else if _, err := os.Stat(keys.IdentityPath()); errors.Is(err, os.ErrNotExist) {
+		if err := keys.generate(); err != nil {
+			return nil, err
+		}
+	}
+	identities, err := keys.loadIdentities()
+	if err != nil {
+		return nil, err
+	}
+	recipients := []age.Recipient{}
+	for _, identity := range identities {
+		x25519, ok := identity.(*age.X25519Identity)
+		if !ok {
+			return nil, fmt.Errorf("%v can only contain X25519 identities to encrypt with", keys.IdentityPath())
+		}
+		recipients = append(recipients, x25519.Recipient())
+	}
+	return recipients, nil
+}
+
+// generate creates a new identity file in the same format as age-keygen
+func (keys *Keys) generate() error {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return err
+	}
+	path := keys.IdentityPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data := fmt.Sprintf("# created by grind, share this with your team but never commit it\n# public key: %v\n%v\n", identity.Recipient(), identity)
+	return os.WriteFile(path, []byte(data), 0600)
+}
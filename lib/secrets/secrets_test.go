@@ -0,0 +1,59 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptWithIdentity(t *testing.T) {
+	t.Setenv(PassphraseEnv, "")
+	t.Setenv(IdentityEnv, "")
+	keys := &Keys{Dir: t.TempDir()}
+	_, err := keys.Decrypt([]byte("nope"))
+	assert.ErrorContains(t, err, "no key to decrypt secrets")
+
+	keys = &Keys{Dir: keys.Dir}
+	data, err := keys.Encrypt([]byte("API_KEY=abc123\n"))
+	require.Nil(t, err)
+	assert.True(t, IsEncrypted(data))
+	info, err := os.Stat(filepath.Join(keys.Dir, ".grind", "key.txt"))
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	path := filepath.Join(keys.Dir, "secrets.env.enc")
+	require.Nil(t, os.WriteFile(path, data, 0644))
+	plain, encrypted, err := (&Keys{Dir: keys.Dir}).ReadFile(path)
+	require.Nil(t, err)
+	assert.True(t, encrypted)
+	assert.Equal(t, "API_KEY=abc123\n", string(plain))
+}
+
+func TestEncryptWithPassphrase(t *testing.T) {
+	t.Setenv(PassphraseEnv, "correct horse battery staple")
+	dir := t.TempDir()
+	data, err := (&Keys{Dir: dir}).Encrypt([]byte("TOKEN=xyz"))
+	require.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, ".grind", "key.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	plain, err := (&Keys{Dir: dir}).Decrypt(data)
+	require.Nil(t, err)
+	assert.Equal(t, "TOKEN=xyz", string(plain))
+
+	t.Setenv(PassphraseEnv, "wrong")
+	_, err = (&Keys{Dir: dir}).Decrypt(data)
+	assert.NotNil(t, err)
+}
+
+func TestReadFilePlain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	require.Nil(t, os.WriteFile(path, []byte("A=1\n"), 0644))
+	plain, encrypted, err := (&Keys{}).ReadFile(path)
+	require.Nil(t, err)
+	assert.False(t, encrypted)
+	assert.Equal(t, "A=1\n", string(plain))
+}
@@ -3,6 +3,7 @@ package term
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 	"text/template"
 	"unicode"
@@ -20,39 +21,75 @@ const (
 
 var spinGlyphs = []rune("⠋⠙⠹⠸⠼⠴⠦⠧⠇⠏")
 
-var funcMap = template.FuncMap{
-	"bright":                  ansiStyler("3", "9"),
-	"Bright":                  ansiStyler("4", "10"),
-	"bold":                    ansiStyler("1"),
-	"faint":                   ansiStyler("2"),
-	"italic":                  ansiStyler("3"),
-	"underline":               ansiStyler("4"),
-	"blink":                   ansiStyler("5"),
-	"fblink":                  ansiStyler("6"),
-	"invert":                  ansiStyler("7"),
-	"conceal":                 ansiStyler("8"),
-	"strike":                  ansiStyler("9"),
-	"black":                   ansiStyler("30"),
-	"red":                     ansiStyler("31"),
-	"green":                   ansiStyler("32"),
-	"yellow":                  ansiStyler("33"),
-	"blue":                    ansiStyler("34"),
-	"magenta":                 ansiStyler("35"),
-	"cyan":                    ansiStyler("36"),
-	"white":                   ansiStyler("37"),
-	"Black":                   ansiStyler("40"),
-	"Red":                     ansiStyler("41"),
-	"Green":                   ansiStyler("42"),
-	"Yellow":                  ansiStyler("43"),
-	"Blue":                    ansiStyler("44"),
-	"Magenta":                 ansiStyler("45"),
-	"Cyan":                    ansiStyler("46"),
-	"White":                   ansiStyler("47"),
-	"super":                   ansiStyler("73"),
-	"sub":                     ansiStyler("74"),
-	"spin":                    spin,
+// ansiFuncs are the template funcs that style text for the terminal
+var ansiFuncs = template.FuncMap{
+	"bright":    ansiStyler("3", "9"),
+	"Bright":    ansiStyler("4", "10"),
+	"bold":      ansiStyler("1"),
+	"faint":     ansiStyler("2"),
+	"italic":    ansiStyler("3"),
+	"underline": ansiStyler("4"),
+	"blink":     ansiStyler("5"),
+	"fblink":    ansiStyler("6"),
+	"invert":    ansiStyler("7"),
+	"conceal":   ansiStyler("8"),
+	"strike":    ansiStyler("9"),
+	"black":     ansiStyler("30"),
+	"red":       ansiStyler("31"),
+	"green":     ansiStyler("32"),
+	"yellow":    ansiStyler("33"),
+	"blue":      ansiStyler("34"),
+	"magenta":   ansiStyler("35"),
+	"cyan":      ansiStyler("36"),
+	"white":     ansiStyler("37"),
+	"Black":     ansiStyler("40"),
+	"Red":       ansiStyler("41"),
+	"Green":     ansiStyler("42"),
+	"Yellow":    ansiStyler("43"),
+	"Blue":      ansiStyler("44"),
+	"Magenta":   ansiStyler("45"),
+	"Cyan":      ansiStyler("46"),
+	"White":     ansiStyler("47"),
+	"super":     ansiStyler("73"),
+	"sub":       ansiStyler("74"),
+	"spin":      spin,
+}
+
+// textFuncs are the template funcs that only deal with plain text
+var textFuncs = template.FuncMap{
 	"trimTrailingWhitespaces": trimRightSpace,
 	"rpad":                    rpad,
+	"upper":                   strings.ToUpper,
+	"lower":                   strings.ToLower,
+	"trim":                    strings.TrimSpace,
+	"trimPrefix":              func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix":              func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":                 func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"contains":                func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":               func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":               func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"split":                   func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":                    func(sep string, elems []string) string { return strings.Join(elems, sep) },
+	"quote":                   strconv.Quote,
+	"default":                 defaultValue,
+}
+
+var funcMap = mergeFuncs(ansiFuncs, textFuncs)
+
+// TextFuncs are the template funcs without any that output ansi codes, for
+// rendering plain text like config files.
+func TextFuncs() template.FuncMap {
+	return mergeFuncs(textFuncs)
+}
+
+func mergeFuncs(maps ...template.FuncMap) template.FuncMap {
+	merged := template.FuncMap{}
+	for _, funcs := range maps {
+		for name, fn := range funcs {
+			merged[name] = fn
+		}
+	}
+	return merged
 }
 
 var spinIndex int
@@ -143,6 +180,15 @@ func rpad(s string, padding int) string {
 	return fmt.Sprintf(formattedString, s)
 }
 
+// defaultValue returns the default if the value is empty, so it can be piped
+// like {{env "PORT" | default "8080"}}
+func defaultValue(def, val string) string {
+	if val == "" {
+		return def
+	}
+	return val
+}
+
 func trimRightSpace(s string) string {
 	return strings.TrimRightFunc(s, unicode.IsSpace)
 }
@@ -0,0 +1,201 @@
+//go:build !windows
+// +build !windows
+
+package term
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	keyCtrlC     = 0x03
+	keyBackspace = 0x7f
+	keyCtrlH     = 0x08
+	keyEnter     = '\r'
+	keyNewline   = '\n'
+	keyEscape    = 0x1b
+
+	confirmTemplate = `{{if .Done}}{{"✔" | green | bold}}{{else}}{{"?" | green | bold}}{{end}} {{.Message | bold}} {{if .Done}}{{.Value | cyan}}{{else}}{{"[y/N]" | faint}}{{end}}`
+	inputTemplate   = `{{if .Done}}{{"✔" | green | bold}}{{else}}{{"?" | green | bold}}{{end}} {{.Message | bold}} {{if .Done}}{{.Value | cyan}}{{else}}{{if .Default}}{{print "(" .Default ") " | faint}}{{end}}{{.Value}}{{end}}`
+	selectTemplate  = `{{if .Done}}{{"✔" | green | bold}}{{else}}{{"?" | green | bold}}{{end}} {{.Message | bold}} {{if .Done}}{{.Value | cyan}}{{else}}{{"(use arrow keys)" | faint}}
+{{- range $i, $opt := .Options}}
+{{if eq $i $.Index}}  {{"❯" | cyan | bold}} {{$opt | cyan}}{{else}}    {{$opt}}{{end}}
+{{- end}}{{end}}`
+)
+
+// ErrInterrupt is returned from a prompt when the user presses ctrl-c
+var ErrInterrupt = errors.New("interrupted")
+
+// Prompter asks questions on a terminal, redrawing the question with a
+// ScreenBuf as the user types.
+type Prompter struct {
+	in   io.Reader
+	term *Terminal
+	out  *ScreenBuf
+}
+
+type promptState struct {
+	Message string
+	Default string
+	Value   string
+	Options []string
+	Index   int
+	Done    bool
+}
+
+// NewPrompter creates a prompter that reads keys from a terminal. The terminal
+// is put into raw mode for each prompt and restored after.
+func NewPrompter(in *os.File, out io.Writer) (*Prompter, error) {
+	terminal, err := NewTerminal(int(in.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	return &Prompter{in: in, term: terminal, out: NewScreenBuf(out)}, nil
+}
+
+// Confirm asks a yes or no question, which defaults to no.
+func (p *Prompter) Confirm(msg string) (bool, error) {
+	state := &promptState{Message: msg}
+	confirmed := false
+	err := p.run(confirmTemplate, state, func(key []byte) bool {
+		switch strings.ToLower(string(key)) {
+		case "y":
+			confirmed = true
+		case "n", "\r", "\n":
+		default:
+			return false
+		}
+		return true
+	})
+	if confirmed {
+		state.Value = "yes"
+	} else {
+		state.Value = "no"
+	}
+	return confirmed, p.done(confirmTemplate, state, err)
+}
+
+// Input asks for a line of text, using the default if nothing is entered.
+func (p *Prompter) Input(msg, def string) (string, error) {
+	return p.input(msg, def, false)
+}
+
+// Password asks for a line of text without showing what has been typed.
+func (p *Prompter) Password(msg string) (string, error) {
+	return p.input(msg, "", true)
+}
+
+func (p *Prompter) input(msg, def string, mask bool) (string, error) {
+	state := &promptState{Message: msg, Default: def}
+	value := []rune{}
+	err := p.run(inputTemplate, state, func(key []byte) bool {
+		switch key[0] {
+		case keyEnter, keyNewline:
+			return true
+		case keyBackspace, keyCtrlH:
+			if len(value) > 0 {
+				value = value[:len(value)-1]
+			}
+		default:
+			if key[0] >= ' ' {
+				value = append(value, []rune(string(key))...)
+			}
+		}
+		if mask {
+			state.Value = strings.Repeat("*", len(value))
+		} else {
+			state.Value = string(value)
+		}
+		return false
+	})
+	result := string(value)
+	if result == "" {
+		result = def
+	}
+	state.Value = result
+	if mask {
+		state.Value = strings.Repeat("*", len(value))
+	}
+	return result, p.done(inputTemplate, state, err)
+}
+
+// Select asks to pick one of the options with the arrow keys.
+func (p *Prompter) Select(msg string, options []string, def string) (string, error) {
+	if len(options) == 0 {
+		return "", errors.New("select prompt requires options")
+	}
+	state := &promptState{Message: msg, Options: options}
+	if i := slices.Index(options, def); i >= 0 {
+		state.Index = i
+	}
+	err := p.run(selectTemplate, state, func(key []byte) bool {
+		switch string(key) {
+		case "\r", "\n":
+			return true
+		case "\x1b[A", "k":
+			state.Index = (state.Index - 1 + len(options)) % len(options)
+		case "\x1b[B", "j":
+			state.Index = (state.Index + 1) % len(options)
+		}
+		return false
+	})
+	state.Value = options[state.Index]
+	return state.Value, p.done(selectTemplate, state, err)
+}
+
+// run renders the prompt and passes every key pressed to the handler until it
+// returns true to signal that the prompt is complete.
+func (p *Prompter) run(tmpl string, state *promptState, handle func([]byte) bool) error {
+	if p.term != nil {
+		if err := p.term.Raw(); err != nil {
+			return err
+		}
+		defer p.term.Restore()
+	}
+	buf := make([]byte, 64)
+	for {
+		if err := p.out.Render(tmpl, state); err != nil {
+			return err
+		}
+		n, err := p.in.Read(buf)
+		if err != nil {
+			return err
+		}
+		for _, key := range splitKeys(buf[:n]) {
+			if key[0] == keyCtrlC {
+				return ErrInterrupt
+			} else if handle(key) {
+				return nil
+			}
+		}
+	}
+}
+
+func (p *Prompter) done(tmpl string, state *promptState, err error) error {
+	if err != nil {
+		return err
+	}
+	state.Done = true
+	return p.out.Render(tmpl, state)
+}
+
+// splitKeys breaks up a read into single key presses, keeping escape sequences
+// like the arrow keys together.
+func splitKeys(input []byte) [][]byte {
+	keys := [][]byte{}
+	for len(input) > 0 {
+		_, size := utf8.DecodeRune(input)
+		if input[0] == keyEscape && len(input) >= 3 && input[1] == '[' {
+			size = 3
+		}
+		keys = append(keys, input[:size])
+		input = input[size:]
+	}
+	return keys
+}
@@ -0,0 +1,69 @@
+package term
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPrompter(input string) (*Prompter, *bytes.Buffer) {
+	var out bytes.Buffer
+	return &Prompter{in: strings.NewReader(input), out: NewScreenBuf(&out)}, &out
+}
+
+func TestPrompterConfirm(t *testing.T) {
+	p, out := testPrompter("xY")
+	ok, err := p.Confirm("Delete everything?")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Contains(t, out.String(), "yes")
+
+	p, _ = testPrompter("\r")
+	ok, err = p.Confirm("Delete everything?")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	p, _ = testPrompter("\x03")
+	_, err = p.Confirm("Delete everything?")
+	assert.Equal(t, ErrInterrupt, err)
+}
+
+func TestPrompterInput(t *testing.T) {
+	p, _ := testPrompter("bo\x7fob\r")
+	val, err := p.Input("Name", "anon")
+	assert.Nil(t, err)
+	assert.Equal(t, "bob", val)
+
+	p, _ = testPrompter("\r")
+	val, err = p.Input("Name", "anon")
+	assert.Nil(t, err)
+	assert.Equal(t, "anon", val)
+}
+
+func TestPrompterPassword(t *testing.T) {
+	p, out := testPrompter("hunter2\r")
+	val, err := p.Password("Password")
+	assert.Nil(t, err)
+	assert.Equal(t, "hunter2", val)
+	assert.NotContains(t, out.String(), "hunter2")
+	assert.Contains(t, out.String(), "*******")
+}
+
+func TestPrompterSelect(t *testing.T) {
+	p, _ := testPrompter("\x1b[B\x1b[B\r")
+	val, err := p.Select("Env", []string{"dev", "staging", "prod"}, "dev")
+	assert.Nil(t, err)
+	assert.Equal(t, "prod", val)
+
+	p, _ = testPrompter("\x1b[A\r")
+	val, err = p.Select("Env", []string{"dev", "staging", "prod"}, "staging")
+	assert.Nil(t, err)
+	assert.Equal(t, "dev", val)
+}
+
+func TestSplitKeys(t *testing.T) {
+	keys := splitKeys([]byte("a\x1b[Bé\r"))
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("\x1b[B"), []byte("é"), []byte("\r")}, keys)
+}
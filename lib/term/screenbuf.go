@@ -111,7 +111,7 @@ func (s *ScreenBuf) write(in string, data interface{}) error {
 	s.mut.Lock()
 	defer s.mut.Unlock()
 	width, _, err := term.GetSize(int(os.Stdin.Fd()))
-	if err != nil {
+	if err != nil || width <= 0 {
 		width = defaultTermWidth
 	}
 	tmpl := wrapANSI(in, width)
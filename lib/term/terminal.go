@@ -0,0 +1,65 @@
+//go:build !windows
+// +build !windows
+
+package term
+
+import (
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// Terminal controls the input mode of a terminal file descriptor, remembering
+// its original state so that it can always be restored.
+type Terminal struct {
+	fd   int
+	orig unix.Termios
+}
+
+// IsTerminal returns true if the file descriptor is a terminal
+func IsTerminal(fd int) bool {
+	return term.IsTerminal(fd)
+}
+
+// NewTerminal will capture the current state of the terminal
+func NewTerminal(fd int) (*Terminal, error) {
+	termios, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		return nil, err
+	}
+	return &Terminal{fd: fd, orig: *termios}, nil
+}
+
+// Cooked sets the terminal back to line mode with echo, with eol as an extra
+// character that will end the line so that it can be read without hitting enter.
+func (t *Terminal) Cooked(eol byte) error {
+	termios := t.orig
+	termios.Cc[unix.VEOL] = eol
+	return unix.IoctlSetTermios(t.fd, ioctlWriteTermios, &termios)
+}
+
+// Cbreak makes every key available to read as it is pressed without echoing
+// it. Signals like ctrl-c and output processing still behave as usual.
+func (t *Terminal) Cbreak() error {
+	termios := t.orig
+	termios.Lflag &^= unix.ECHO | unix.ICANON
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	return unix.IoctlSetTermios(t.fd, ioctlWriteTermios, &termios)
+}
+
+// Raw passes all input through untouched, including signal keys, so that it
+// can be forwarded to another terminal. Unlike term.MakeRaw, output processing
+// is left on so that newlines written to the screen still return the cursor.
+func (t *Terminal) Raw() error {
+	termios := t.orig
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	return unix.IoctlSetTermios(t.fd, ioctlWriteTermios, &termios)
+}
+
+// Restore sets the terminal back to the state it was in when it was captured
+func (t *Terminal) Restore() error {
+	return unix.IoctlSetTermios(t.fd, ioctlWriteTermios, &t.orig)
+}